@@ -23,6 +23,7 @@ package starx
 import (
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/chrislonng/starx/cluster"
@@ -38,7 +39,9 @@ import (
 type acceptor struct {
 	id         int64
 	socket     net.Conn
+	writeMu    sync.Mutex // serialize writes, requests are handled by multiple workers
 	status     networkStatus
+	mu         sync.RWMutex               // protect following session maps
 	sessionMap map[int64]*session.Session // backend sessions
 	f2bMap     map[int64]int64            // frontend session id -> backend session id map
 	b2fMap     map[int64]int64            // backend session id -> frontend session id map
@@ -71,6 +74,9 @@ func (a *acceptor) heartbeat() {
 }
 
 func (a *acceptor) Session(sid int64) *session.Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	if bsid, ok := a.f2bMap[sid]; ok && bsid > 0 {
 		return a.sessionMap[bsid]
 	}
@@ -81,9 +87,42 @@ func (a *acceptor) Session(sid int64) *session.Session {
 	return s
 }
 
+// frontendID returns the frontend session id of backend session
+func (a *acceptor) frontendID(bsid int64) (int64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	sid, ok := a.b2fMap[bsid]
+	return sid, ok
+}
+
+// sessions returns all backend sessions
+func (a *acceptor) sessions() []*session.Session {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	sessions := make([]*session.Session, 0, len(a.sessionMap))
+	for _, s := range a.sessionMap {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// removeSession removes the backend session
+func (a *acceptor) removeSession(bsid int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.sessionMap, bsid)
+	if fid, ok := a.b2fMap[bsid]; ok {
+		delete(a.b2fMap, bsid)
+		delete(a.f2bMap, fid)
+	}
+}
+
 func (a *acceptor) Close() {
 	a.status = statusClosed
-	for _, s := range a.sessionMap {
+	for _, s := range a.sessions() {
 		transporter.closeSession(s)
 	}
 	transporter.removeAcceptor(a)
@@ -95,10 +134,21 @@ func (a *acceptor) ID() int64 {
 }
 
 func (a *acceptor) Send(data []byte) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
 	_, err := a.socket.Write(data)
 	return err
 }
 
+// write writes rpc response to frontend server, it is safe for concurrent use
+func (a *acceptor) write(resp *rpc.Response) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+
+	return rpc.WriteResponse(a.socket, resp)
+}
+
 func (a *acceptor) Push(session *session.Session, route string, v interface{}) error {
 	data, err := serializeOrRaw(v)
 	if err != nil {
@@ -107,13 +157,13 @@ func (a *acceptor) Push(session *session.Session, route string, v interface{}) e
 
 	log.Debugf("UID=%d, Type=Push, Route=%s, Data=%+v", session.Uid, route, v)
 
-	rs, err := transporter.acceptor(session.Entity.ID())
+	rs, err := transporter.acceptor(session.NetworkEntity().ID())
 	if err != nil {
 		log.Errorf(err.Error())
 		return err
 	}
 
-	sid, ok := rs.b2fMap[session.ID]
+	sid, ok := rs.frontendID(session.ID)
 	if !ok {
 		log.Errorf("sid not exists")
 		return ErrSidNotExists
@@ -125,7 +175,38 @@ func (a *acceptor) Push(session *session.Session, route string, v interface{}) e
 		Data:  data,
 		Sid:   sid,
 	}
-	return rpc.WriteResponse(a.socket, resp)
+	return a.write(resp)
+}
+
+// PushReliable forwards the reliable push to the frontend server which the
+// session belongs to
+func (a *acceptor) PushReliable(session *session.Session, route string, v interface{}) error {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("UID=%d, Type=ReliablePush, Route=%s, Data=%+v", session.Uid, route, v)
+
+	rs, err := transporter.acceptor(session.NetworkEntity().ID())
+	if err != nil {
+		log.Errorf(err.Error())
+		return err
+	}
+
+	sid, ok := rs.frontendID(session.ID)
+	if !ok {
+		log.Errorf("sid not exists")
+		return ErrSidNotExists
+	}
+
+	resp := &rpc.Response{
+		Route: route,
+		Kind:  rpc.HandlerReliablePush,
+		Data:  data,
+		Sid:   sid,
+	}
+	return a.write(resp)
 }
 
 // Response message to session
@@ -137,13 +218,13 @@ func (a *acceptor) Response(session *session.Session, v interface{}) error {
 
 	log.Debugf("UID=%d, Type=Response, Data=%+v", session.Uid, v)
 
-	rs, err := transporter.acceptor(session.Entity.ID())
+	rs, err := transporter.acceptor(session.NetworkEntity().ID())
 	if err != nil {
 		log.Errorf(err.Error())
 		return err
 	}
 
-	sid, ok := rs.b2fMap[session.ID]
+	sid, ok := rs.frontendID(session.ID)
 	if !ok {
 		log.Errorf("sid not exists")
 		return ErrSidNotExists
@@ -153,7 +234,37 @@ func (a *acceptor) Response(session *session.Session, v interface{}) error {
 		Data: data,
 		Sid:  sid,
 	}
-	return rpc.WriteResponse(a.socket, resp)
+	return a.write(resp)
+}
+
+// Kick session, the kick message will be forwarded to the frontend server
+// which the session belongs to
+func (a *acceptor) Kick(session *session.Session, v interface{}) error {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("UID=%d, Type=Kick, Data=%+v", session.Uid, v)
+
+	rs, err := transporter.acceptor(session.NetworkEntity().ID())
+	if err != nil {
+		log.Errorf(err.Error())
+		return err
+	}
+
+	sid, ok := rs.frontendID(session.ID)
+	if !ok {
+		log.Errorf("sid not exists")
+		return ErrSidNotExists
+	}
+
+	resp := &rpc.Response{
+		Kind: rpc.HandlerKick,
+		Data: data,
+		Sid:  sid,
+	}
+	return a.write(resp)
 }
 
 func (a *acceptor) Call(session *session.Session, route string, reply interface{}, args ...interface{}) error {
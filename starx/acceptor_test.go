@@ -0,0 +1,64 @@
+package starx
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/chrislonng/starx/cluster/rpc"
+	"github.com/chrislonng/starx/packet"
+)
+
+// splitConn writes data in two parts, which is not safe for concurrent writers
+// like websocket connection
+type splitConn struct {
+	net.Conn
+}
+
+func (c splitConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b[:len(b)/2])
+	if err != nil {
+		return n, err
+	}
+	runtime.Gosched()
+	m, err := c.Conn.Write(b[len(b)/2:])
+	return n + m, err
+}
+
+func TestAcceptorConcurrentWrite(t *testing.T) {
+	const count = 100
+
+	// pushes from multiple workers should not be interleaved
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	ac := transporter.createAcceptor(splitConn{server})
+	defer transporter.removeAcceptor(ac)
+
+	for i := 1; i <= count; i++ {
+		s := ac.Session(int64(i))
+		wg.Add(1)
+		go func(n byte) {
+			defer wg.Done()
+			s.Push("test.push", []byte{n})
+		}(byte(i))
+	}
+
+	dec := packet.NewDecoder(client, 0)
+	for i := 0; i < count; i++ {
+		p, err := dec.Decode(rpc.Framing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &rpc.Response{}
+		if _, err := resp.UnmarshalMsg(p.Data); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Kind != rpc.HandlerPush || len(resp.Data) != 1 || resp.Sid != int64(resp.Data[0]) {
+			t.Fatalf("wrong response: %+v", resp)
+		}
+	}
+}
@@ -24,33 +24,54 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chrislonng/starx/cluster"
 	"github.com/chrislonng/starx/cluster/rpc"
+	"github.com/chrislonng/starx/codec"
 	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/message"
 	"github.com/chrislonng/starx/packet"
 	routelib "github.com/chrislonng/starx/route"
+	"github.com/chrislonng/starx/service"
 	"github.com/chrislonng/starx/session"
+	"github.com/chrislonng/starx/timer"
 )
 
 var (
 	ErrRPCLocal          = errors.New("RPC object must location in different server type")
 	ErrSidNotExists      = errors.New("sid not exists")
 	ErrSendChannelClosed = errors.New("agent send channel closed")
+	ErrSendQueueFull     = errors.New("agent send queue full")
+	ErrSendTimeout       = errors.New("agent send timeout")
 )
 
 // Agent corresponding a user, used for store raw socket information
 // only used in package internal, can not accessible by other package
 type agent struct {
-	id         int64
-	socket     net.Conn
-	status     networkStatus
-	session    *session.Session
-	sendBuffer chan []byte
-	recvBuffer chan *packet.Packet
-	die        chan bool
-	lastTime   int64 // last heartbeat unix time stamp
+	mu             sync.RWMutex // protects id and session, which are replaced when session resumed
+	id             int64
+	ip             string // remote ip, used by admission control
+	socket         net.Conn
+	status         networkStatus
+	session        *session.Session
+	queue          *sendQueue // outbound packets in order, control packets are never dropped when overflows
+	recvBuffer     chan *packet.Packet
+	die            chan bool
+	lastTime       int64             // last received unix nano time stamp, atomic
+	heartbeatTimer *timer.WheelTimer // heartbeat check timer, nil when heartbeat disabled
+	wire           atomic.Value      // wire protocol negotiated in handshake
+	cipher         *sessionCipher    // nil when encryption disabled, only used in logic goroutine
+	resumeToken    string            // token for resuming session after reconnect, empty when resume disabled
+}
+
+// wireProtocol represents the wire protocol options negotiated in handshake
+type wireProtocol struct {
+	codec    codec.Codec // wire codec
+	compress bool        // client supports data compression
+	encrypt  bool        // data packets are sealed after handshake ack
 }
 
 // Create new agent instance
@@ -58,11 +79,12 @@ func newAgent(conn net.Conn) *agent {
 	a := &agent{
 		socket:     conn,
 		status:     statusStart,
-		lastTime:   time.Now().Unix(),
-		sendBuffer: make(chan []byte, packetBufferSize),
+		lastTime:   time.Now().UnixNano(),
+		queue:      newSendQueue(packetBufferSize, packetBufferSize),
 		recvBuffer: make(chan *packet.Packet, packetBufferSize),
 		die:        make(chan bool, 1),
 	}
+	a.wire.Store(wireProtocol{codec: codec.Default})
 	s := session.New(a)
 	a.session = s
 	a.id = s.ID
@@ -72,55 +94,225 @@ func newAgent(conn net.Conn) *agent {
 
 // String, implementation for Stringer interface
 func (a *agent) String() string {
-	return fmt.Sprintf("Id=%d, Remote=%s, LastTime=%d",
-		a.id,
+	return fmt.Sprintf("Id=%d, Remote=%s, LastTime=%d, QueueDepth=%d",
+		a.ID(),
 		a.socket.RemoteAddr().String(),
-		a.lastTime)
+		atomic.LoadInt64(&a.lastTime),
+		a.queueDepth())
+}
+
+// RemoteAddr returns the client address, refs transport.NewProxyListener
+func (a *agent) RemoteAddr() net.Addr {
+	return a.socket.RemoteAddr()
+}
+
+// queueDepth returns the count of packets waiting to be written
+func (a *agent) queueDepth() int {
+	return a.queue.len()
+}
+
+func (a *agent) loadStatus() networkStatus {
+	return networkStatus(atomic.LoadInt32((*int32)(&a.status)))
+}
+
+func (a *agent) setStatus(s networkStatus) {
+	atomic.StoreInt32((*int32)(&a.status), int32(s))
+}
+
+// isControlPacket reports whether the packet should never be dropped when send
+// queue overflows, only data packets of push and response are droppable
+func (a *agent) isControlPacket(data []byte) bool {
+	if len(data) == 0 || packet.PacketType(data[0]) != packet.Data {
+		return true
+	}
+	t, err := message.TypeOf(data[a.codec().HeadLength():])
+	return err == nil && t == message.ReliablePush
+}
+
+// protocol returns the wire protocol which current agent used, it will be
+// read in network goroutine and set in logic goroutine
+func (a *agent) protocol() wireProtocol {
+	return a.wire.Load().(wireProtocol)
+}
+
+func (a *agent) setProtocol(p wireProtocol) {
+	a.wire.Store(p)
 }
 
+func (a *agent) codec() codec.Codec {
+	return a.protocol().codec
+}
+
+// seal data packet when encryption has been activated, other packets will be
+// returned directly, only called in logic goroutine to keep nonce in order
+func (a *agent) seal(data []byte) ([]byte, error) {
+	if a.cipher == nil || !a.cipher.active || len(data) == 0 || data[0] != packet.Data {
+		return data, nil
+	}
+
+	c := a.codec()
+	p, _, err := c.Unpack(data)
+	if err != nil {
+		return nil, err
+	}
+	p.Data = a.cipher.seal(p.Data)
+	return c.Pack(p)
+}
+
+// heartbeat records the time of receiving packet, called in network goroutine
 func (a *agent) heartbeat() {
-	a.lastTime = time.Now().Unix()
+	atomic.StoreInt64(&a.lastTime, time.Now().UnixNano())
+}
+
+// quiet returns the duration since last packet received, pomelo client only
+// sends heartbeat to answer server, so quiet client should be heartbeated even
+// it is receiving pushes, otherwise it will be closed by the read deadline
+func (a *agent) quiet() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&a.lastTime))
 }
 
 func (a *agent) Close() {
-	if a.status == statusClosed {
-		return
+	a.close(false)
+}
+
+// lost closes the agent whose connection has been lost, the session will be
+// suspended for resuming when client has been issued a resume token
+func (a *agent) lost() {
+	a.close(true)
+}
+
+// close closes agent exactly once, it could be called from any goroutine
+func (a *agent) close(lost bool) {
+	var prev networkStatus
+	for {
+		prev = a.loadStatus()
+		if prev == statusClosed {
+			return
+		}
+		if atomic.CompareAndSwapInt32((*int32)(&a.status), int32(prev), int32(statusClosed)) {
+			break
+		}
 	}
+	suspend := lost && env.resumeGrace > 0 && a.resumeToken != "" && prev == statusWorking
 
-	a.status = statusClosed
-	log.Debugf("Session closed, Id=%d, IP=%s", a.session.ID, a.socket.RemoteAddr())
+	if a.heartbeatTimer != nil {
+		a.heartbeatTimer.Stop()
+	}
+	log.Debugf("Session closed, Id=%d, IP=%s", a.ID(), a.socket.RemoteAddr())
 
 	a.die <- true
 
-	// close all channel
+	// close all channel, receive buffer is left to the garbage collector,
+	// the read loop may still be delivering packets into it
 	close(a.die)
-	close(a.recvBuffer)
-	close(a.sendBuffer)
+	a.queue.close()
 
-	transporter.closeSession(a.session)
+	if suspend {
+		transporter.suspend(a)
+	} else {
+		transporter.closeSession(a.Session())
+	}
+	service.Connections.Release(a.ip)
 	a.socket.Close()
 }
 
 func (a *agent) ID() int64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
 	return a.id
 }
 
-func (a *agent) Send(data []byte) (err error) {
-	defer func() {
-		if e := recover(); err != nil {
-			if er, ok := e.(error); ok {
-				err = er
-			}
-		}
-	}()
+// Session returns the session which agent attached to
+func (a *agent) Session() *session.Session {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.session
+}
+
+// attach attaches agent to the session, e.g. session resumed
+func (a *agent) attach(s *session.Session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.id = s.ID
+	a.session = s
+}
+
+func (a *agent) Send(data []byte) error {
+	if a.loadStatus() >= statusClosed {
+		return ErrSendChannelClosed
+	}
+
+	if a.isControlPacket(data) {
+		return a.sendControl(data)
+	}
+
+	ok, err := a.queue.tryPush(data, false)
+	if err != nil || ok {
+		return err
+	}
+
+	// send queue is full, client can not catch up with the server
+	return a.overflow(data)
+}
+
+// sendControl queues the control packet, control packets are never dropped,
+// agent will be closed when the packet can not be queued in time
+func (a *agent) sendControl(data []byte) error {
+	timeout := env.sendTimeout
+	if timeout <= 0 {
+		timeout = kickFlushTimeout
+	}
+
+	err := a.queue.push(data, true, timeout)
+	if err == ErrSendTimeout {
+		log.Infof("Control packet can not be queued, session will be closed, %s", a.String())
+		a.Close()
+	}
+	return err
+}
 
-	if a.status < statusClosed {
-		a.sendBuffer <- data
-		return nil
+// trySend queues data without blocking, false will be returned when the
+// send queue is full or closed
+func (a *agent) trySend(data []byte) bool {
+	if a.loadStatus() >= statusClosed {
+		return false
 	}
 
-	err = ErrSendChannelClosed
-	return
+	ok, _ := a.queue.tryPush(data, a.isControlPacket(data))
+	return ok
+}
+
+// overflow handles the packet which can not be queued immediately, refs
+// OverflowPolicy
+func (a *agent) overflow(data []byte) error {
+	switch env.overflowPolicy {
+	case OverflowDropOldest:
+		dropped, err := a.queue.dropOldest(data)
+		if dropped || err == ErrSendQueueFull {
+			service.Stats.IncrementDroppedPackets()
+		}
+		return err
+
+	case OverflowDropNewest:
+		service.Stats.IncrementDroppedPackets()
+		return ErrSendQueueFull
+
+	case OverflowDisconnect:
+		log.Infof("Send queue full, session will be closed, %s", a.String())
+		service.Stats.IncrementDroppedPackets()
+		a.Close()
+		return ErrSendQueueFull
+
+	default:
+		err := a.queue.push(data, false, env.sendTimeout)
+		if err == ErrSendTimeout {
+			service.Stats.IncrementDroppedPackets()
+		}
+		return err
+	}
 }
 
 func (a *agent) Push(session *session.Session, route string, v interface{}) error {
@@ -134,6 +326,23 @@ func (a *agent) Push(session *session.Session, route string, v interface{}) erro
 	return transporter.push(session, route, data)
 }
 
+// PushReliable push message to session, refs Session.PushReliable
+func (a *agent) PushReliable(session *session.Session, route string, v interface{}) error {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Type=ReliablePush, UID=%d, Route=%s, Data=%+v", session.Uid, route, v)
+
+	return transporter.pushReliable(session, route, data)
+}
+
+// Bound will be called after session bound to a user
+func (a *agent) Bound(session *session.Session) {
+	transporter.bind(session)
+}
+
 // Response message to session
 func (a *agent) Response(session *session.Session, v interface{}) error {
 	data, err := serializeOrRaw(v)
@@ -146,6 +355,19 @@ func (a *agent) Response(session *session.Session, v interface{}) error {
 	return transporter.response(session, data)
 }
 
+// Kick message to session, the connection will be closed after the kick
+// packet has been written to socket
+func (a *agent) Kick(session *session.Session, v interface{}) error {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Type=Kick, UID=%d, Data=%+v", session.Uid, v)
+
+	return transporter.kick(session, data)
+}
+
 func (a *agent) Call(session *session.Session, route string, reply interface{}, args ...interface{}) error {
 	r, err := routelib.Decode(route)
 	if err != nil {
@@ -0,0 +1,233 @@
+package starx
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/component"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/session"
+)
+
+// fullAgent creates an agent whose send queue is full of push packets, the
+// data of n-th push is byte n
+func fullAgent(t *testing.T, policy OverflowPolicy) *agent {
+	env.overflowPolicy = policy
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	for i := 0; i < a.queue.size; i++ {
+		if err := a.Send(pushPacket(t, byte(i), message.Push)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a
+}
+
+func pushPacket(t *testing.T, n byte, typ message.MessageType) []byte {
+	data, err := packMessage(session.New(nil), &message.Message{Type: typ, Route: "test.overflow", Data: []byte{n}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func pushData(t *testing.T, data []byte) byte {
+	p, _, err := codec.Default.Unpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := codec.Default.Decode(p.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Data[0]
+}
+
+func popQueued(t *testing.T, a *agent) []byte {
+	data, ok := a.queue.pop()
+	if !ok {
+		t.Fatal("no packet queued")
+	}
+	return data
+}
+
+// waitQueued waits a packet queued to agent, nil will be returned when nothing
+// queued before timeout
+func waitQueued(a *agent, timeout time.Duration) []byte {
+	expired := time.After(timeout)
+	for {
+		if data, ok := a.queue.pop(); ok {
+			return data
+		}
+		select {
+		case <-a.queue.ready:
+		case <-expired:
+			return nil
+		}
+	}
+}
+
+// control packets are queued although send queue is full
+func sendControls(t *testing.T, a *agent) {
+	kick, err := codec.Default.Pack(&packet.Packet{Type: packet.Kick})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{kick, pushPacket(t, 0, message.ReliablePush)} {
+		if err := a.Send(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.queueDepth() != a.queue.size+2 {
+		t.Fatal("control packets should never be dropped")
+	}
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	defer func(p OverflowPolicy) { env.overflowPolicy = p }(env.overflowPolicy)
+
+	a := fullAgent(t, OverflowDropNewest)
+	if err := a.Send(pushPacket(t, 0xFF, message.Push)); err != ErrSendQueueFull {
+		t.Fatalf("expect ErrSendQueueFull, got %v", err)
+	}
+	sendControls(t, a)
+	if n := pushData(t, popQueued(t, a)); n != 0 {
+		t.Errorf("the oldest packet should be kept, got %d", n)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	defer func(p OverflowPolicy) { env.overflowPolicy = p }(env.overflowPolicy)
+
+	a := fullAgent(t, OverflowDropOldest)
+	if err := a.Send(pushPacket(t, 0xFF, message.Push)); err != nil {
+		t.Fatal(err)
+	}
+	sendControls(t, a)
+	if n := pushData(t, popQueued(t, a)); n != 1 {
+		t.Errorf("the oldest packet should be dropped, got %d", n)
+	}
+	for i := 2; i < a.queue.size; i++ {
+		popQueued(t, a)
+	}
+	if n := pushData(t, popQueued(t, a)); n != 0xFF {
+		t.Errorf("the newest packet should be queued, got %d", n)
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	defer func(p OverflowPolicy) { env.overflowPolicy = p }(env.overflowPolicy)
+
+	a := fullAgent(t, OverflowDisconnect)
+	sendControls(t, a)
+
+	// concurrent senders close the agent only once
+	data := pushPacket(t, 0xFF, message.Push)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Send(data)
+		}()
+	}
+	wg.Wait()
+	if a.loadStatus() != statusClosed {
+		t.Error("slow client should be disconnected")
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	defer func(p OverflowPolicy, d time.Duration) {
+		env.overflowPolicy, env.sendTimeout = p, d
+	}(env.overflowPolicy, env.sendTimeout)
+	env.sendTimeout = 10 * time.Millisecond
+
+	a := fullAgent(t, OverflowBlock)
+	if err := a.Send(pushPacket(t, 0xFF, message.Push)); err != ErrSendTimeout {
+		t.Fatalf("expect ErrSendTimeout, got %v", err)
+	}
+	sendControls(t, a)
+}
+
+func TestSendQueueOrder(t *testing.T) {
+	defer func(p OverflowPolicy) { env.overflowPolicy = p }(env.overflowPolicy)
+
+	// data and control packets are dequeued in the order they were sent,
+	// dropping the oldest packet skips control packets
+	a := fullAgent(t, OverflowDropOldest)
+	for i := 0; i < a.queue.size; i++ {
+		popQueued(t, a)
+	}
+	packets := []struct {
+		n   byte
+		typ message.MessageType
+	}{
+		{1, message.ReliablePush},
+		{2, message.Push},
+		{3, message.ReliablePush},
+		{4, message.Push},
+	}
+	for _, p := range packets {
+		if err := a.Send(pushPacket(t, p.n, p.typ)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 5; i < a.queue.size+4; i++ {
+		if err := a.Send(pushPacket(t, byte(i), message.Push)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []byte{1, 3, 4} {
+		if got := pushData(t, popQueued(t, a)); got != n {
+			t.Fatalf("expect packet %d, got %d", n, got)
+		}
+	}
+}
+
+type OrderComp struct {
+	component.Base
+}
+
+func (c *OrderComp) HandleResponseKick(s *session.Session, m *JsonMessage) error {
+	if err := s.Response(m); err != nil {
+		return err
+	}
+	return s.Kick([]byte("bye"))
+}
+
+// TestResponseBeforeKick responds the request and kicks the client in the
+// handler, the client receives both in order before connection closed
+func TestResponseBeforeKick(t *testing.T) {
+	SetSerializer(json.NewSerializer())
+	handler.register(&OrderComp{})
+
+	client, done := serveConn()
+	defer client.Close()
+	c := &testClient{t: t, conn: client, dec: packet.NewDecoder(client, 0)}
+	c.handshake()
+	c.send(&message.Message{Type: message.Request, ID: 1, Route: "OrderComp.HandleResponseKick"},
+		JsonMessage{Code: 1, Data: "hello"})
+
+	p := c.read()
+	if p.Type != packet.Data {
+		t.Fatalf("expect response before kick, got %v", p)
+	}
+	if m, err := codec.Default.Decode(p.Data); err != nil || m.Type != message.Response || m.ID != 1 {
+		t.Fatalf("wrong response: %v %v", m, err)
+	}
+	if p := c.read(); p.Type != packet.Kick || string(p.Data) != "bye" {
+		t.Fatalf("expect kick packet, got %v", p)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.dec.Decode(codec.Default); err != io.EOF {
+		t.Fatalf("connection should be closed after kick, got %v", err)
+	}
+	waitDone(t, done)
+}
@@ -21,16 +21,19 @@
 package starx
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/chrislonng/starx/cluster"
 	"github.com/chrislonng/starx/log"
-	"github.com/gorilla/websocket"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/service"
+	"github.com/chrislonng/starx/transport"
 )
 
 func welcomeMsg() {
@@ -40,33 +43,45 @@ func welcomeMsg() {
 func startup() {
 	startupComps()
 
-	go func() {
-		if app.config.IsWebsocket {
-			listenAndServeWS()
-		} else {
-			listenAndServe()
+	// admission control of client connections
+	service.Connections.SetLimits(app.config.MaxConnections, app.config.MaxConnsPerIP, app.config.MaxConnRatePerIP)
+
+	// inflated message is bounded by a small multiple of the max inbound
+	// packet, the package default is used otherwise
+	if app.config.MaxPacketSize > 0 {
+		message.SetMaxInflateSize(message.InflateRatio * app.config.MaxPacketSize)
+	}
+
+	// all listeners feed into the same handler and transporter, and
+	// every listener of frontend serves TLS with its own certificates
+	for _, l := range app.config.AllListeners() {
+		var config *tls.Config
+		if app.config.IsFrontend {
+			var err error
+			if config, err = tlsConfig(l); err != nil {
+				log.Fatal(err.Error())
+			}
 		}
-	}()
+		go listenAndServe(l, config)
+	}
+
+	// graceful restart on SIGUSR2
+	go watchRestart()
 
-	sg := make(chan os.Signal)
-	signal.Notify(sg, syscall.SIGINT)
+	sg := make(chan os.Signal, 1)
+	signal.Notify(sg, syscall.SIGINT, syscall.SIGTERM)
 
-	// stop server
+	// stop server, env.die will be closed after Shutdown called by
+	// application finished
 	select {
 	case <-env.die:
-		log.Infof("The app will shutdown in a few seconds")
 	case s := <-sg:
 		log.Infof("got signal: %v", s)
+		shutdown()
 	}
-
-	log.Infof("server: " + app.config.Id + " is stopping...")
-
-	// shutdown all components registered by application, that
-	// call by reverse order against register
-	shutdownComps()
 }
 
-//extend -> adder: leaffly
+// extend -> adder: leaffly
 var ConnLostCallBacks map[interface{}]func(interface{}) = make(map[interface{}]func(interface{}), 0)
 
 func SaveConnLostCallBack(key interface{}, callBack func(interface{})) (err error) {
@@ -77,8 +92,8 @@ func SaveConnLostCallBack(key interface{}, callBack func(interface{})) (err erro
 	}
 	return err
 }
-func FindConnLostCallBack(key interface{})(fnReturn func(interface{})) {
-	fnReturn = func(interface{}){}
+func FindConnLostCallBack(key interface{}) (fnReturn func(interface{})) {
+	fnReturn = func(interface{}) {}
 	defer func() {
 		if err := recover(); nil != err {
 			log.Error(err)
@@ -90,51 +105,78 @@ func FindConnLostCallBack(key interface{})(fnReturn func(interface{})) {
 
 	return fnReturn
 }
+
 //========================================
 
-// Enable current server accept connection
-func listenAndServe() {
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", app.config.Host, app.config.Port))
+// Enable current server accept connection with the listener's transport
+func listenAndServe(l *cluster.ListenerConfig, config *tls.Config) {
+	t, err := transport.Lookup(l.Protocol)
+	if err != nil {
+		log.Fatalf("%s: %s", l.Protocol, err.Error())
+	}
+
+	listener, err := listen(t, l, config)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	log.Infof("listen at %s:%d(%s)", app.config.Host, app.config.Port, app.config.String())
+	log.Infof("listen at %s(%s)", l.String(), app.config.String())
 
-	defer listener.Close()
+	if !addListener(listener) {
+		listener.Close()
+		return
+	}
+
+	if app.config.IsFrontend {
+		serve(listener, handler.handle)
+	} else {
+		serve(listener, remote.handle)
+	}
+}
+
+// serve accepts connections and handles them in individual goroutines, the
+// frontend handler and backend remote service could be served in the same
+// process, e.g. a gate and its backends on memory transport in tests, it
+// returns after the listener closed
+func serve(listener net.Listener, handle func(net.Conn)) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			// listener has been closed by shutdown
+			if isShuttingDown() || err == transport.ErrListenerClosed {
+				return
+			}
 			log.Errorf(err.Error())
 			continue
 		}
-		if app.config.IsFrontend {
-			go handler.handle(conn)
-		} else {
-			go remote.handle(conn)
-		}
+		go handle(conn)
 	}
 }
 
-func listenAndServeWS() {
-	var upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin:     env.checkOrigin,
+// listen announces on the listener address, the raw listener inherited from
+// parent process will be adopted on graceful restart
+func listen(t transport.Transport, l *cluster.ListenerConfig, config *tls.Config) (net.Listener, error) {
+	it, ok := t.(transport.Inheritable)
+	if !ok {
+		return t.Listen(l.Address(), config)
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Error(err)
-			return
+	raw := inheritedListener(l.String())
+	if raw != nil {
+		log.Infof("adopt inherited listener %s", l.String())
+	} else {
+		var err error
+		if raw, err = it.Announce(l.Address()); err != nil {
+			return nil, err
 		}
+	}
 
-		handler.HandleWS(conn)
-	})
+	// raw listener will be passed to new process on graceful restart
+	addRawListener(l.String(), raw)
 
-	addr := fmt.Sprintf("%s:%d", app.config.Host, app.config.Port)
-	log.Infof("listen at %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatal(err.Error())
+	// real client address is sent by load balancer before any data, only
+	// client connections of frontend come through the load balancer
+	if app.config.ProxyProtocol && app.config.IsFrontend {
+		raw = transport.NewProxyListener(raw)
 	}
+	return it.Serve(raw, l.Address(), config)
 }
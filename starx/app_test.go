@@ -0,0 +1,153 @@
+package starx
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/component"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/session"
+	"github.com/chrislonng/starx/transport"
+)
+
+type ListenerComp struct {
+	component.Base
+	count int32
+}
+
+// HandleCount replies the count of requests handled
+func (c *ListenerComp) HandleCount(s *session.Session, m *JsonMessage) (*JsonMessage, error) {
+	return &JsonMessage{Code: int(atomic.AddInt32(&c.count, 1)), Data: m.Data}, nil
+}
+
+var listenerComp = &ListenerComp{}
+
+// TestListenersTCPAndWebSocket connects a tcp client and a websocket client to
+// the listeners of one server simultaneously, both reach the same handler
+func TestListenersTCPAndWebSocket(t *testing.T) {
+	SetSerializer(json.NewSerializer())
+	handler.register(listenerComp)
+
+	config := &cluster.ServerConfig{
+		Host: "127.0.0.1",
+		Listeners: []*cluster.ListenerConfig{
+			{Protocol: cluster.ProtocolTCP},
+			{Protocol: cluster.ProtocolWS, Path: "/ws"},
+		},
+	}
+
+	// connections are handled until clients closed
+	done := make(chan struct{}, len(config.Listeners))
+	handle := func(conn net.Conn) {
+		handler.handle(conn)
+		done <- struct{}{}
+	}
+
+	var clients []*testClient
+	for _, l := range config.AllListeners() {
+		tr, err := transport.Lookup(l.Protocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := tr.Listen(l.Address(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go serve(listener, handle)
+
+		// listen on a random port, websocket path is appended
+		addr := listener.Addr().String()
+		if l.Protocol == cluster.ProtocolWS {
+			addr += l.Path
+		}
+		conn, err := tr.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		c := &testClient{t: t, conn: conn, dec: packet.NewDecoder(conn, 0)}
+		c.handshake()
+		clients = append(clients, c)
+	}
+
+	count := atomic.LoadInt32(&listenerComp.count)
+	for i, c := range clients {
+		c.send(&message.Message{Type: message.Request, ID: uint(i + 1), Route: "ListenerComp.HandleCount"},
+			JsonMessage{Data: config.Listeners[i].Protocol})
+	}
+	for i, c := range clients {
+		p := c.read()
+		m, err := codec.Default.Decode(p.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := &JsonMessage{}
+		if err := serializer.Deserialize(m.Data, reply); err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != message.Response || m.ID != uint(i+1) || reply.Data != config.Listeners[i].Protocol {
+			t.Errorf("wrong response: %s, %+v", m, reply)
+		}
+	}
+	if n := atomic.LoadInt32(&listenerComp.count) - count; n != 2 {
+		t.Errorf("expect 2 requests handled, got %d", n)
+	}
+
+	// server config is modified by other tests after connections closed
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	for range clients {
+		<-done
+	}
+}
+
+// TestListenProxyProtocol accepts a connection without proxy protocol header,
+// which is rejected by frontend and served by backend
+func TestListenProxyProtocol(t *testing.T) {
+	proxy, frontend := app.config.ProxyProtocol, app.config.IsFrontend
+	defer func() {
+		app.config.ProxyProtocol, app.config.IsFrontend = proxy, frontend
+		rawListenersLock.Lock()
+		rawListeners = nil
+		rawListenersLock.Unlock()
+	}()
+	app.config.ProxyProtocol = true
+
+	for _, isFrontend := range []bool{true, false} {
+		app.config.IsFrontend = isFrontend
+		listener, err := listen(transport.TCP, &cluster.ListenerConfig{Protocol: cluster.ProtocolTCP, Host: "127.0.0.1"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("starx"))
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		if isFrontend && err != transport.ErrNoProxyHeader {
+			t.Errorf("frontend expects %v, got %v", transport.ErrNoProxyHeader, err)
+		}
+		if !isFrontend && (err != nil || string(buf) != "starx") {
+			t.Errorf("backend should not parse proxy protocol header, got %q %v", buf, err)
+		}
+
+		c.Close()
+		conn.Close()
+		listener.Close()
+	}
+}
@@ -1,8 +1,6 @@
 package cluster
 
 import (
-	"errors"
-
 	"github.com/chrislonng/starx/cluster/rpc"
 	"github.com/chrislonng/starx/log"
 	"github.com/chrislonng/starx/route"
@@ -23,9 +21,9 @@ func Call(rpcKind rpc.RpcKind, route *route.Route, session *session.Session, arg
 		return nil, err
 	}
 	reply := new([]byte)
-	err = client.Call(rpcKind, route.Service, route.Method, session.Entity.ID(), reply, args)
+	err = client.Call(rpcKind, route.Service, route.Method, session.NetworkEntity().ID(), reply, args)
 	if err != nil {
-		return nil, errors.New(err.Error())
+		return nil, err
 	}
 	return *reply, nil
 }
@@ -37,6 +35,6 @@ func SessionClosed(session *session.Session) {
 			continue
 		}
 
-		client.Call(rpc.Sys, sessionClosedRoute.Service, sessionClosedRoute.Method, session.Entity.ID(), nil, nil)
+		client.Call(rpc.Sys, sessionClosedRoute.Service, sessionClosedRoute.Method, session.NetworkEntity().ID(), nil, nil)
 	}
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/chrislonng/starx/cluster/rpc"
 	"github.com/chrislonng/starx/log"
 	"github.com/chrislonng/starx/session"
+	"github.com/chrislonng/starx/transport"
 )
 
 var (
@@ -158,15 +159,16 @@ func UpdateServer(newSvr *ServerConfig) {
 
 func CloseClient(svrId string) {
 	mutex.Lock()
-	defer mutex.Unlock()
-
 	client, ok := clientIdMaps[svrId]
 	if !ok {
+		mutex.Unlock()
 		log.Infof("%s not found in rpc client list", svrId)
 		return
 	}
-
 	delete(clientIdMaps, svrId)
+	// unlock before dumping clients, mutex is not reentrant
+	mutex.Unlock()
+
 	client.Close()
 
 	log.Infof("%s rpc client has been removed.", svrId)
@@ -232,10 +234,17 @@ func Client(svrId string) (*rpc.Client, error) {
 		return nil, errors.New(svr.Id + " is frontend server, can handle rpc request")
 	}
 
-	client, err := rpc.Dial("tcp4", fmt.Sprintf("%s:%d", svr.Host, svr.Port))
+	// backend server accepts rpc connections with the first listener
+	l := svr.AllListeners()[0]
+	t, err := transport.Lookup(l.Protocol)
 	if err != nil {
 		return nil, err
 	}
+	conn, err := t.Dial(l.Address())
+	if err != nil {
+		return nil, err
+	}
+	client = rpc.NewClient(conn)
 	log.Infof("%s establish rpc client successful.", svr.Id)
 
 	// on client shutdown
@@ -261,6 +270,10 @@ func Client(svrId string) (*rpc.Client, error) {
 				s.Push(resp.Route, resp.Data)
 			case rpc.HandlerResponse:
 				s.Response(resp.Data)
+			case rpc.HandlerKick:
+				s.Kick(resp.Data)
+			case rpc.HandlerReliablePush:
+				s.PushReliable(resp.Route, resp.Data)
 			default:
 				log.Errorf("invalid response kind")
 			}
@@ -282,12 +295,18 @@ func DumpClientIdMaps() {
 
 func Close() {
 	mutex.Lock()
+	clients := make([]*rpc.Client, 0, len(clientIdMaps))
+	for _, client := range clientIdMaps {
+		clients = append(clients, client)
+	}
+	clientIdMaps = make(map[string]*rpc.Client)
+	// unlock before closing clients, shutdown callbacks of clients acquire
+	// the mutex
 	mutex.Unlock()
 
 	// close all RPC clients
 	log.Infof("close all of socket connections")
-	for id, client := range clientIdMaps {
-		delete(clientIdMaps, id)
+	for _, client := range clients {
 		client.Close()
 	}
 }
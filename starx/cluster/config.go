@@ -3,14 +3,107 @@ package cluster
 import "fmt"
 
 type ServerConfig struct {
-	Type        string `json:"type"`
-	Id          string `json:"id"`
-	Host        string `json:"host"`
-	Port        int    `json:"port"`
-	IsFrontend  bool   `json:"is_frontend"`
-	IsMaster    bool   `json:"is_master"`
-	IsWebsocket bool   `json:"is_websocket"`
-	HeartbeatDetalSecond int `json:"heartbeat_DeltaSecond"`
+	Type                 string `json:"type"`
+	Id                   string `json:"id"`
+	Host                 string `json:"host"`
+	Port                 int    `json:"port"`
+	IsFrontend           bool   `json:"is_frontend"`
+	IsMaster             bool   `json:"is_master"`
+	IsWebsocket          bool   `json:"is_websocket"`
+	HeartbeatDetalSecond int    `json:"heartbeat_DeltaSecond"`
+	Encrypt              bool   `json:"encrypt"`          // encrypt data packets with the key exchanged in handshake, refs starx.SetSigningKey
+	MaxPacketSize        int    `json:"max_packet_size"`  // max inbound packet data length, 0 means packet.DefaultMaxPacketSize
+	MaxMessageSize       int    `json:"max_message_size"` // max outbound message length, 0 means packet.DefaultMaxPacketSize
+	CertFile             string `json:"cert_file"`        // serve TLS(or WSS) when certificate specified, default of listeners requiring TLS, frontend only
+	KeyFile              string `json:"key_file"`         // private key of the certificate
+	ClientCA             string `json:"client_ca"`        // verify client certificate with the ca when specified
+	MaxConnections       int    `json:"max_connections"`  // max client connections of frontend, 0 means unlimited
+	MaxConnsPerIP        int    `json:"max_conns_per_ip"` // max client connections per ip, 0 means unlimited
+	MaxConnRatePerIP     int    `json:"max_conn_rate"`    // max new connections per ip per second, 0 means unlimited
+	ProxyProtocol        bool   `json:"proxy_protocol"`   // parse PROXY protocol header on tcp and ws listeners of frontend
+
+	// Listeners declares multiple listeners of frontend server, Host, Port
+	// and IsWebsocket will be used as the only listener when it is empty
+	Listeners []*ListenerConfig `json:"listeners"`
+}
+
+// ListenerConfig represents a listener of frontend server, all listeners feed
+// into the same handler, so sessions from all transports could be grouped
+type ListenerConfig struct {
+	Protocol string `json:"protocol"`  // transport name, e.g. tcp, ws, unix and memory
+	Host     string `json:"host"`      // listen host, default to server host
+	Port     int    `json:"port"`      // listen port
+	Path     string `json:"path"`      // websocket path(default to /) or unix socket path
+	TLS      bool   `json:"tls"`       // serve TLS(or WSS) with certificate of server when certificate not specified
+	CertFile string `json:"cert_file"` // serve TLS(or WSS) on the listener with the certificate
+	KeyFile  string `json:"key_file"`  // private key of the certificate
+	ClientCA string `json:"client_ca"` // verify client certificate with the ca when specified
+}
+
+// Address returns the address which the transport listen on
+func (c *ListenerConfig) Address() string {
+	switch c.Protocol {
+	case ProtocolWS:
+		return fmt.Sprintf("%s:%d%s", c.Host, c.Port, c.Path)
+	case ProtocolUnix:
+		return c.Path
+	default:
+		return fmt.Sprintf("%s:%d", c.Host, c.Port)
+	}
+}
+
+func (c *ListenerConfig) String() string {
+	return c.Protocol + "://" + c.Address()
+}
+
+// Builtin listener protocols, refs package transport
+const (
+	ProtocolTCP    = "tcp"
+	ProtocolWS     = "ws"
+	ProtocolUnix   = "unix"
+	ProtocolMemory = "memory"
+)
+
+// AllListeners returns all listeners of the server, default values of
+// listener will be filled, the first listener is the rpc address of backend
+// server. Listeners requiring TLS without certificate use the certificate of
+// server, other listeners without certificate serve plain connections
+func (c *ServerConfig) AllListeners() []*ListenerConfig {
+	if len(c.Listeners) == 0 {
+		l := &ListenerConfig{
+			Protocol: ProtocolTCP,
+			Host:     c.Host,
+			Port:     c.Port,
+			Path:     "/",
+			TLS:      c.CertFile != "",
+			CertFile: c.CertFile,
+			KeyFile:  c.KeyFile,
+			ClientCA: c.ClientCA,
+		}
+		if c.IsWebsocket {
+			l.Protocol = ProtocolWS
+		}
+		return []*ListenerConfig{l}
+	}
+
+	listeners := make([]*ListenerConfig, 0, len(c.Listeners))
+	for _, l := range c.Listeners {
+		copied := *l
+		if copied.Protocol == "" {
+			copied.Protocol = ProtocolTCP
+		}
+		if copied.Host == "" {
+			copied.Host = c.Host
+		}
+		if copied.Path == "" && copied.Protocol == ProtocolWS {
+			copied.Path = "/"
+		}
+		if copied.TLS && copied.CertFile == "" {
+			copied.CertFile, copied.KeyFile, copied.ClientCA = c.CertFile, c.KeyFile, c.ClientCA
+		}
+		listeners = append(listeners, &copied)
+	}
+	return listeners
 }
 
 func (c *ServerConfig) String() string {
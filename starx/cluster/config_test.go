@@ -0,0 +1,78 @@
+package cluster
+
+import "testing"
+
+func TestAllListenersLegacy(t *testing.T) {
+	cases := []struct {
+		websocket bool
+		protocol  string
+		address   string
+	}{
+		{false, ProtocolTCP, "127.0.0.1:3250"},
+		{true, ProtocolWS, "127.0.0.1:3250/"},
+	}
+
+	// the only listener is declared by host, port and websocket flag when
+	// listeners are empty
+	for _, c := range cases {
+		config := &ServerConfig{Host: "127.0.0.1", Port: 3250, IsWebsocket: c.websocket}
+		listeners := config.AllListeners()
+		if len(listeners) != 1 {
+			t.Fatalf("expect 1 listener, got %d", len(listeners))
+		}
+		l := listeners[0]
+		if l.Protocol != c.protocol || l.Address() != c.address || l.TLS {
+			t.Errorf("wrong listener with websocket %v: %+v", c.websocket, l)
+		}
+	}
+
+	// legacy listener serves TLS with certificate of server
+	config := &ServerConfig{Host: "127.0.0.1", Port: 3250, CertFile: "cert.pem", KeyFile: "key.pem"}
+	if l := config.AllListeners()[0]; !l.TLS || l.CertFile != "cert.pem" || l.KeyFile != "key.pem" {
+		t.Errorf("legacy listener should use certificate of server: %+v", l)
+	}
+}
+
+func TestAllListenersDefaults(t *testing.T) {
+	config := &ServerConfig{
+		Host:        "127.0.0.1",
+		Port:        3250,
+		IsWebsocket: true,
+		CertFile:    "cert.pem",
+		KeyFile:     "key.pem",
+		Listeners: []*ListenerConfig{
+			{Port: 3251},
+			{Protocol: ProtocolWS, Port: 3252},
+			{Protocol: ProtocolWS, Host: "0.0.0.0", Port: 3253, Path: "/ws", TLS: true},
+			{Protocol: ProtocolUnix, Path: "/tmp/starx.sock"},
+		},
+	}
+
+	// host, port and websocket flag of server are ignored when listeners
+	// declared, protocol, host and websocket path are filled
+	expects := []struct {
+		protocol string
+		address  string
+		certFile string
+	}{
+		{ProtocolTCP, "127.0.0.1:3251", ""},
+		{ProtocolWS, "127.0.0.1:3252/", ""},
+		{ProtocolWS, "0.0.0.0:3253/ws", "cert.pem"},
+		{ProtocolUnix, "/tmp/starx.sock", ""},
+	}
+	listeners := config.AllListeners()
+	if len(listeners) != len(expects) {
+		t.Fatalf("expect %d listeners, got %d", len(expects), len(listeners))
+	}
+	for i, e := range expects {
+		l := listeners[i]
+		if l.Protocol != e.protocol || l.Address() != e.address || l.CertFile != e.certFile {
+			t.Errorf("wrong listener %d: %+v", i, l)
+		}
+	}
+
+	// declared listeners are not modified
+	if config.Listeners[0].Protocol != "" || config.Listeners[1].Path != "" {
+		t.Error("declared listeners should not be modified")
+	}
+}
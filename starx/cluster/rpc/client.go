@@ -2,12 +2,12 @@ package rpc
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"sync"
 
 	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/packet"
 )
 
 // ServerError represents an error that has been returned from
@@ -18,6 +18,17 @@ func (e ServerError) Error() string {
 	return string(e)
 }
 
+// CodedError represents an error with code that has been returned from
+// the remote side of the RPC connection.
+type CodedError struct {
+	Code int
+	Msg  string
+}
+
+func (e *CodedError) Error() string {
+	return e.Msg
+}
+
 var (
 	ErrShutdown        = errors.New("connection is shut down")
 	ErrRequestOverFlow = errors.New("request too long")
@@ -67,7 +78,7 @@ type Client struct {
 // discarded.
 type clientCodec struct {
 	rw  io.ReadWriteCloser
-	buf []byte // save buffer data
+	dec *packet.Decoder // response decoder
 }
 
 func (codec *clientCodec) close() error {
@@ -80,6 +91,11 @@ func (client *Client) writeRequest() error {
 		log.Errorf(err.Error())
 		return err
 	}
+	data, err = frame(data)
+	if err != nil {
+		log.Errorf(err.Error())
+		return err
+	}
 	_, err = client.codec.rw.Write(data)
 	return err
 }
@@ -125,56 +141,52 @@ func (client *Client) send(rpcKind RpcKind, call *Call) {
 
 func (client *Client) input() {
 	var err error
-	var response *Response
-	var tmp = make([]byte, 512)
 	for err == nil {
-		n, err := client.codec.rw.Read(tmp)
+		var p *packet.Packet
+		p, err = client.codec.dec.Decode(Framing)
 		if err != nil {
-			fmt.Println(err.Error())
 			break
 		}
-		client.codec.buf = append(client.codec.buf, tmp[:n]...)
-		for {
-			response = &Response{}
-			client.codec.buf, err = response.UnmarshalMsg(client.codec.buf)
-			if err != nil {
-				//log.Error(err.Error())
-				break
-			}
-			if response.Kind == HandlerPush || response.Kind == HandlerResponse {
-				client.ResponseChan <- response
-				continue
-			}
-			seq := response.Seq
-			client.mutex.Lock()
-			call := client.pending[seq]
-			delete(client.pending, seq)
-			client.mutex.Unlock()
 
-			switch {
-			case call == nil:
-				// We've got no pending call. That usually means that
-				// WriteRequest partially failed, and call was already
-				// removed; response is a server telling us about an
-				// error reading request body. We should still attempt
-				// to read error body, but there's no one to give it to.
-				// err = errors.New("reading error body")
-			case response.Error != "":
-				// We've got an error response. Give this to the request;
-				// any subsequent requests will get the ReadResponseBody
-				// error if there is one.
+		response := &Response{}
+		_, err = response.UnmarshalMsg(p.Data)
+		packet.Release(p)
+		if err != nil {
+			log.Errorf(err.Error())
+			break
+		}
+
+		if response.Kind == HandlerPush || response.Kind == HandlerResponse || response.Kind == HandlerKick ||
+			response.Kind == HandlerReliablePush {
+			client.ResponseChan <- response
+			continue
+		}
+		seq := response.Seq
+		client.mutex.Lock()
+		call := client.pending[seq]
+		delete(client.pending, seq)
+		client.mutex.Unlock()
+
+		switch {
+		case call == nil:
+			// We've got no pending call. That usually means that
+			// WriteRequest partially failed, and call was already
+			// removed; response is a server telling us about an
+			// error reading request body. We should still attempt
+			// to read error body, but there's no one to give it to.
+		case response.Error != "":
+			// We've got an error response. Give this to the request;
+			// any subsequent requests will get the ReadResponseBody
+			// error if there is one.
+			if response.Code != 0 {
+				call.Error = &CodedError{Code: response.Code, Msg: response.Error}
+			} else {
 				call.Error = ServerError(response.Error)
-				if err != nil {
-					err = errors.New("reading error body: " + err.Error())
-				}
-				call.done()
-			default:
-				if err != nil {
-					call.Error = errors.New("reading body " + err.Error())
-				}
-				*call.Reply = response.Data
-				call.done()
 			}
+			call.done()
+		default:
+			*call.Reply = response.Data
+			call.done()
 		}
 	}
 	// Terminate pending calls.
@@ -224,7 +236,7 @@ func NewClient(conn io.ReadWriteCloser) *Client {
 	client := &Client{
 		codec: &clientCodec{
 			rw:  conn,
-			buf: make([]byte, 0),
+			dec: packet.NewDecoder(conn, 0),
 		},
 		pending:      make(map[uint64]*Call),
 		ResponseChan: make(chan *Response, 2<<10),
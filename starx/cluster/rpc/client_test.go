@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chrislonng/starx/packet"
+)
+
+func TestClient_Call(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+
+	// echo server
+	go func() {
+		dec := packet.NewDecoder(sc, 0)
+		for {
+			p, err := dec.Decode(Framing)
+			if err != nil {
+				return
+			}
+			req := &Request{}
+			if _, err := req.UnmarshalMsg(p.Data); err != nil {
+				t.Error(err)
+				return
+			}
+			packet.Release(p)
+
+			resp := &Response{
+				Kind:          RemoteResponse,
+				ServiceMethod: req.ServiceMethod,
+				Seq:           req.Seq,
+				Sid:           req.Sid,
+				Data:          req.Data,
+			}
+			if err := WriteResponse(sc, resp); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	client := NewClient(cc)
+	defer client.Close()
+
+	for _, arg := range []string{"hello", "world"} {
+		reply := new([]byte)
+		if err := client.Call(Sys, "Test", "Echo", 1, reply, []byte(arg)); err != nil {
+			t.Fatal(err)
+		}
+		if string(*reply) != arg {
+			t.Errorf("expect %s, got %s", arg, string(*reply))
+		}
+	}
+}
@@ -4,18 +4,21 @@ package rpc
 type ResponseKind byte
 
 const (
-	HandlerResponse ResponseKind = 0x1 // handler session response
-	HandlerPush                  = 0x2 // handler session push
-	RemoteResponse               = 0x3 // remote request normal response, represent whether rpc call successfully
-	RemotePush                   = 0x4 // using remote server push message to current server
+	HandlerResponse     ResponseKind = 0x1 // handler session response
+	HandlerPush                      = 0x2 // handler session push
+	RemoteResponse                   = 0x3 // remote request normal response, represent whether rpc call successfully
+	RemotePush                       = 0x4 // using remote server push message to current server
+	HandlerKick                      = 0x5 // handler session kick
+	HandlerReliablePush              = 0x6 // handler session reliable push
 )
 
 type RpcKind byte
 
 const (
-	_    RpcKind = iota
-	Sys          // sys namespace rpc
-	User         // user namespace rpc
+	_         RpcKind = iota
+	Sys               // sys namespace rpc
+	User              // user namespace rpc
+	SysNotify         // sys namespace rpc forwarded from client notify, which has no response
 )
 
 // Request is a header written before every RPC call.  It is used internally
@@ -39,5 +42,6 @@ type Response struct {
 	Sid           int64        // frontend session id
 	Data          []byte       // save response value
 	Error         string       // error, if any.
+	Code          int          // error code, exists when error is typed
 	Route         string       // exists when ResponseType equal RPC_HANDLER_PUSH
 }
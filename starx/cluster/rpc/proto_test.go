@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func FuzzRequestUnmarshalMsg(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := &Request{}
+		if _, err := r.UnmarshalMsg(data); err != nil {
+			return
+		}
+
+		// decoded request should be encoded and decoded again
+		b, err := r.MarshalMsg(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r2 := &Request{}
+		if _, err := r2.UnmarshalMsg(b); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Data) == 0 && len(r2.Data) == 0 {
+			r.Data, r2.Data = nil, nil
+		}
+		if !reflect.DeepEqual(r, r2) {
+			t.Errorf("not equal, %+v, %+v", r, r2)
+		}
+	})
+}
+
+func TestResponseCode(t *testing.T) {
+	r := &Response{
+		Kind:  RemoteResponse,
+		Seq:   1,
+		Sid:   2,
+		Error: "route not found",
+		Code:  404,
+	}
+	b, err := r.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > r.Msgsize() {
+		t.Errorf("wrong message size, %d > %d", len(b), r.Msgsize())
+	}
+	r2 := &Response{}
+	if _, err := r2.UnmarshalMsg(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, r2) {
+		t.Errorf("not equal, %+v, %+v", r, r2)
+	}
+}
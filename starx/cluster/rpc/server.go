@@ -9,6 +9,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/packet"
 )
 
 var (
@@ -37,9 +38,11 @@ func isExported(name string) bool {
 }
 
 var rpcResponseKindNames = []string{
-	HandlerResponse: "HandlerResponse",
-	HandlerPush:     "HandlerPush",
-	RemoteResponse:  "RemoteResponse",
+	HandlerResponse:     "HandlerResponse",
+	HandlerPush:         "HandlerPush",
+	RemoteResponse:      "RemoteResponse",
+	HandlerKick:         "HandlerKick",
+	HandlerReliablePush: "HandlerReliablePush",
 }
 
 func (k ResponseKind) String() string {
@@ -50,8 +53,9 @@ func (k ResponseKind) String() string {
 }
 
 var rpcKindNames = []string{
-	Sys:  "SysRpc",  // system rpc
-	User: "UserRpc", // user rpc
+	Sys:       "SysRpc",       // system rpc
+	User:      "UserRpc",      // user rpc
+	SysNotify: "SysNotifyRpc", // system rpc of notify
 }
 
 func (k RpcKind) String() string {
@@ -67,7 +71,20 @@ func WriteResponse(w io.Writer, resp *Response) error {
 		log.Errorf(err.Error())
 		return err
 	}
+	data, err = frame(data)
+	if err != nil {
+		log.Errorf(err.Error())
+		return err
+	}
 	// TODO: n
 	_, err = w.Write(data)
 	return err
 }
+
+// Framing of rpc stream, every request and response is wrapped as a data
+// packet, so the stream can be read by packet.Decoder
+var Framing = packet.V2
+
+func frame(data []byte) ([]byte, error) {
+	return packet.PackV2(&packet.Packet{Type: packet.Data, Data: data})
+}
@@ -0,0 +1,125 @@
+package codec
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+)
+
+// Codec represents a wire protocol, which owns packet framing and message
+// encoding, codec is selected per connection during the handshake, the first
+// byte of a packed packet must be the packet type
+type Codec interface {
+	packet.Framing
+	Name() string
+	Pack(p *packet.Packet) ([]byte, error)
+	Unpack(data []byte) (*packet.Packet, []byte, error)
+	Encode(m *message.Message) ([]byte, error)
+	Decode(data []byte) (*message.Message, error)
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = make(map[string]Codec)
+)
+
+// Default is the pomelo compatible codec, which is used before handshake
+// and the codec client does not provide
+var Default Codec = &v1{}
+
+func init() {
+	Register(Default)
+	Register(&v2{})
+}
+
+// Register a codec, the codec with the same name will be replaced
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	codecs[c.Name()] = c
+}
+
+// Lookup returns the codec registered with name
+func Lookup(name string) (Codec, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c, ok := codecs[strings.TrimSpace(name)]
+	return c, ok
+}
+
+// Negotiate select the first registered codec in names, return the default
+// codec when no one is registered
+func Negotiate(names []string) Codec {
+	for _, name := range names {
+		if c, ok := Lookup(name); ok {
+			return c
+		}
+	}
+	return Default
+}
+
+// v1 is the pomelo protocol, 3 bytes packet length and variant length message id
+type v1 struct{}
+
+func (*v1) Name() string {
+	return "v1"
+}
+
+func (*v1) HeadLength() int {
+	return packet.HeadLength
+}
+
+func (*v1) ParseHead(head []byte) (packet.PacketType, int, error) {
+	return packet.ParseHead(head)
+}
+
+func (*v1) Pack(p *packet.Packet) ([]byte, error) {
+	return packet.Pack(p)
+}
+
+func (*v1) Unpack(data []byte) (*packet.Packet, []byte, error) {
+	return packet.Unpack(data)
+}
+
+func (*v1) Encode(m *message.Message) ([]byte, error) {
+	return message.Encode(m)
+}
+
+func (*v1) Decode(data []byte) (*message.Message, error) {
+	return message.Decode(data)
+}
+
+// v2 has 4 bytes packet length and 64 bits message id
+type v2 struct{}
+
+func (*v2) Name() string {
+	return "v2"
+}
+
+func (*v2) HeadLength() int {
+	return packet.HeadLengthV2
+}
+
+func (*v2) ParseHead(head []byte) (packet.PacketType, int, error) {
+	return packet.ParseHeadV2(head)
+}
+
+func (*v2) Pack(p *packet.Packet) ([]byte, error) {
+	return packet.PackV2(p)
+}
+
+func (*v2) Unpack(data []byte) (*packet.Packet, []byte, error) {
+	return packet.UnpackV2(data)
+}
+
+func (*v2) Encode(m *message.Message) ([]byte, error) {
+	return message.EncodeV2(m)
+}
+
+func (*v2) Decode(data []byte) (*message.Message, error) {
+	return message.DecodeV2(data)
+}
@@ -0,0 +1,71 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+)
+
+func TestNegotiate(t *testing.T) {
+	if c := Negotiate(nil); c != Default {
+		t.Error("should be default codec")
+	}
+
+	if c := Negotiate([]string{"unknown", "v2", "v1"}); c.Name() != "v2" {
+		t.Error("should be v2 codec")
+	}
+
+	if c := Negotiate([]string{"unknown"}); c != Default {
+		t.Error("should be default codec")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	for _, name := range []string{"v1", "v2"} {
+		c, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("codec %s not found", name)
+		}
+
+		m := &message.Message{
+			Type:  message.Request,
+			ID:    1<<40 + 1,
+			Route: "test.test.test",
+			Data:  []byte("hello world"),
+		}
+		if name == "v1" {
+			m.ID = 100
+		}
+		em, err := c.Encode(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := &packet.Packet{Type: packet.Data, Data: em}
+		pp, err := c.Pack(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		upp, rest, err := c.Unpack(append(pp, 0x01))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rest, []byte{0x01}) {
+			t.Error("wrong rest")
+		}
+		if !reflect.DeepEqual(p, upp) {
+			t.Error("not equal")
+		}
+
+		dm, err := c.Decode(upp.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, dm) {
+			t.Errorf("%s: not equal", name)
+		}
+	}
+}
@@ -42,8 +42,17 @@ func isHandlerMethod(method reflect.Method) bool {
 		return false
 	}
 
-	// Method needs one outs: error
-	if mt.NumOut() != 1 {
+	// Method needs one outs: error, or two outs: []byte or pointer, error.
+	// The method with two outs returns response, which can not handle notify,
+	// notify routed to it is rejected when dispatching, in both frontend and
+	// backend server
+	switch mt.NumOut() {
+	case 1:
+	case 2:
+		if mt.Out(0).Kind() != reflect.Ptr && mt.Out(0) != typeOfBytes {
+			return false
+		}
+	default:
 		return false
 	}
 
@@ -51,7 +60,7 @@ func isHandlerMethod(method reflect.Method) bool {
 		return false
 	}
 
-	if (mt.In(2).Kind() != reflect.Ptr && mt.In(2) != typeOfBytes) || mt.Out(0) != typeOfError {
+	if (mt.In(2).Kind() != reflect.Ptr && mt.In(2) != typeOfBytes) || mt.Out(mt.NumOut()-1) != typeOfError {
 		return false
 	}
 	return true
@@ -92,7 +101,12 @@ func suitableHandlerMethods(typ reflect.Type, reportErr bool) map[string]*Handle
 			if mt.In(2) == typeOfBytes {
 				raw = true
 			}
-			methods[mn] = &HandlerMethod{Method: method, Type: mt.In(2), Raw: raw}
+			methods[mn] = &HandlerMethod{
+				Method: method,
+				Type:   mt.In(2),
+				Raw:    raw,
+				Reply:  mt.NumOut() == 2,
+			}
 		}
 	}
 	return methods
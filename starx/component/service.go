@@ -11,6 +11,7 @@ type HandlerMethod struct {
 	Method   reflect.Method
 	Type     reflect.Type
 	Raw      bool //Whether the data need to serialize
+	Reply    bool // handler returns response, which will be responded automatically, notify is rejected
 	numCalls uint
 }
 
@@ -35,6 +36,8 @@ type Service struct {
 // - two arguments, both of exported type
 // - the first argument is *session.Session
 // - the second argument is []byte or a pointer
+// - returns error, or []byte or a pointer and error, the returned value will
+// be responded to client automatically
 func (s *Service) ScanHandler() error {
 	if s.Name == "" {
 		return errors.New("handler.Register: no service name for type " + s.Type.String())
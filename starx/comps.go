@@ -23,6 +23,7 @@ package starx
 import (
 	"github.com/chrislonng/starx/component"
 	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/message"
 )
 
 var (
@@ -44,11 +45,17 @@ func startupComps() {
 		} else {
 			err = remote.register(c)
 		}
-		if nil != err{
+		if nil != err {
 			log.Error(err)
 		}
 	}
 
+	// generate route dictionary for all handlers and known backend routes,
+	// routes which are set by message.SetDict will keep its code
+	if app.config.IsFrontend {
+		message.AppendDict(append(handler.routes(), env.backendRoutes...))
+	}
+
 	handler.dumpServiceMap()
 	remote.dumpServiceMap()
 }
@@ -21,9 +21,9 @@
 package starx
 
 import (
+	"crypto/ecdsa"
 	"encoding/json"
 	"io"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -32,7 +32,7 @@ import (
 
 	"github.com/chrislonng/starx/cluster"
 	"github.com/chrislonng/starx/log"
-	"github.com/chrislonng/starx/timer"
+	"github.com/chrislonng/starx/session"
 )
 
 var VERSION = "0.0.1"
@@ -58,7 +58,22 @@ var (
 		heartbeatInternal time.Duration               // heartbeat internal
 		die               chan bool                   // wait for end application
 
-		checkOrigin func(*http.Request) bool // check origin when websocket enabled
+		handshakeValidator func(*session.Session, map[string]interface{}) error // validate client handshake body
+		signingKey         *ecdsa.PrivateKey                                    // signs the server key exchanged in handshake, nil means unsigned
+		routeCompression   map[string]bool                                      // data compression override of route
+		backendRoutes      []string                                             // routes of backend handlers, appended to route dictionary
+		shutdownMessage    interface{}                                          // kick message sent to clients on shutdown
+		shutdownTimeout    time.Duration                                        // max duration waiting for in-flight calls on shutdown
+		overflowPolicy     OverflowPolicy                                       // behavior when send queue of client is full
+		sendTimeout        time.Duration                                        // max duration blocked by full send queue, OverflowBlock only
+		flushInterval      time.Duration                                        // max duration outbound packets delayed for coalescing
+		maxCoalescePackets int                                                  // max count of packets coalesced in a single write
+		resumeGrace        time.Duration                                        // max duration session kept for resuming after connection lost
+		offlineStore       OfflineStore                                         // store reliable pushes for offline users
+		maxPendingPushes   int                                                  // max count of unacked reliable pushes of a session
+		workerCount        int                                                  // count of workers which handle requests in backend server
+		beforeFilters      []BeforeFilter                                       // filters called before handlers
+		afterFilters       []AfterFilter                                        // filters called after handlers
 	}{}
 )
 
@@ -76,7 +91,13 @@ func init() {
 
 	// environment initialize
 	env.settings = make(map[string][]ServerInitFunc)
+	env.routeCompression = make(map[string]bool)
 	env.die = make(chan bool)
+	env.shutdownMessage = []byte("server shutdown")
+	env.shutdownTimeout = defaultShutdownTimeout
+	env.workerCount = defaultWorkerCount
+	env.maxPendingPushes = defaultMaxPendingPushes
+	env.maxCoalescePackets = defaultMaxCoalescePackets
 
 	if wd, err := os.Getwd(); err != nil {
 		panic(err)
@@ -182,12 +203,10 @@ func initServer() {
 	if app.config.IsFrontend {
 		//adder leaffly
 		//set heartbeat deal time
-		if env.heartbeatInternal <= 0{
+		if env.heartbeatInternal <= 0 {
 			env.heartbeatInternal = time.Duration(app.config.HeartbeatDetalSecond) * time.Second
 		}
 
-		timer.Register(env.heartbeatInternal, func() {
-			transporter.heartbeat()
-		})
+		transporter.startHeartbeat(env.heartbeatInternal)
 	}
 }
@@ -20,7 +20,7 @@
 
 package starx
 
-type networkStatus byte
+type networkStatus int32
 
 const (
 	_ networkStatus = iota
@@ -29,3 +29,21 @@ const (
 	statusWorking
 	statusClosed
 )
+
+// OverflowPolicy represents the behavior when the send queue of a client is
+// full, which is usually caused by a slow client, policy only applies to push
+// and response, control packets, e.g. handshake, heartbeat, kick and reliable
+// push, are queued separately and never dropped
+type OverflowPolicy byte
+
+const (
+	// OverflowBlock blocks the sender until the queue has free slot or the
+	// send timeout expired, blocks forever when timeout is not positive
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest data packet in queue
+	OverflowDropOldest
+	// OverflowDropNewest drops the packet being sent
+	OverflowDropNewest
+	// OverflowDisconnect closes the slow client
+	OverflowDisconnect
+)
@@ -0,0 +1,147 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// Nonce direction prefix, client and server share the same session key, so
+// the direction must be contained in nonce to avoid reusing
+const (
+	directionClient uint32 = 0x0 // client -> server
+	directionServer uint32 = 0x1 // server -> client
+)
+
+var (
+	ErrEncryptRequired = errors.New("encryption required, but client public key not found")
+	ErrInvalidPubKey   = errors.New("invalid client public key")
+)
+
+// Length of the GCM tag appended to sealed data
+const sealOverhead = 16
+
+// sessionCipher seals and opens packet data with AES-GCM, the key is derived
+// from the ECDH(P-256) shared secret, and the nonce is composed of direction
+// and an increasing counter, so packets must be sealed and opened in order.
+// It is only used in the agent logic goroutine.
+//
+// The key exchange itself is not authenticated, it only protects against
+// passive eavesdroppers, an active man-in-the-middle could exchange keys with
+// both sides. Configure a signing key with SetSigningKey, and verify the
+// signature of server key with the pinned public key in clients to defend
+// against it.
+type sessionCipher struct {
+	aead    cipher.AEAD
+	active  bool   // become active after handshake ack
+	sendSeq uint64 // server -> client nonce counter
+	recvSeq uint64 // client -> server nonce counter
+}
+
+// newSessionCipher exchange key with client public key(base64 encoded
+// uncompressed P-256 point), returns the cipher and the server public key
+func newSessionCipher(clientKey string) (*sessionCipher, string, error) {
+	raw, err := base64.StdEncoding.DecodeString(clientKey)
+	if err != nil {
+		return nil, "", ErrInvalidPubKey
+	}
+
+	curve := ecdh.P256()
+	pub, err := curve.NewPublicKey(raw)
+	if err != nil {
+		return nil, "", ErrInvalidPubKey
+	}
+
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, "", err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := &sessionCipher{aead: aead}
+	return c, base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// signExchange signs the exchanged public keys(base64 encoded) with the long
+// term key, returns the base64 encoded ASN.1 ECDSA signature of
+// SHA-256(server key || client key), the client key is signed as well, so the
+// signature can not be replayed in other handshakes
+func signExchange(key *ecdsa.PrivateKey, serverKey, clientKey string) (string, error) {
+	server, err := base64.StdEncoding.DecodeString(serverKey)
+	if err != nil {
+		return "", err
+	}
+	client, err := base64.StdEncoding.DecodeString(clientKey)
+	if err != nil {
+		return "", ErrInvalidPubKey
+	}
+
+	digest := sha256.Sum256(append(server, client...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func (c *sessionCipher) nonce(direction uint32, seq uint64) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint32(nonce[:4], direction)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// seal data which will be sent to client
+func (c *sessionCipher) seal(data []byte) []byte {
+	nonce := c.nonce(directionServer, c.sendSeq)
+	c.sendSeq++
+	return c.aead.Seal(nil, nonce, data, nil)
+}
+
+// open data which received from client
+func (c *sessionCipher) open(data []byte) ([]byte, error) {
+	nonce := c.nonce(directionClient, c.recvSeq)
+	c.recvSeq++
+	return c.aead.Open(nil, nonce, data, nil)
+}
@@ -0,0 +1,274 @@
+package starx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+)
+
+// testClientCipher is the client side of the session cipher
+type testClientCipher struct {
+	priv    *ecdh.PrivateKey
+	aead    cipher.AEAD
+	sendSeq uint64
+	recvSeq uint64
+}
+
+func newTestClientCipher(t *testing.T) *testClientCipher {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClientCipher{priv: priv}
+}
+
+func (c *testClientCipher) publicKey() string {
+	return base64.StdEncoding.EncodeToString(c.priv.PublicKey().Bytes())
+}
+
+// exchange derives the session key with server public key
+func (c *testClientCipher) exchange(t *testing.T, serverKey string) {
+	raw, err := base64.StdEncoding.DecodeString(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := c.priv.ECDH(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testClientCipher) nonce(direction uint32, seq uint64) []byte {
+	return (&sessionCipher{aead: c.aead}).nonce(direction, seq)
+}
+
+func (c *testClientCipher) seal(data []byte) []byte {
+	nonce := c.nonce(directionClient, c.sendSeq)
+	c.sendSeq++
+	return c.aead.Seal(nil, nonce, data, nil)
+}
+
+func (c *testClientCipher) open(data []byte) ([]byte, error) {
+	nonce := c.nonce(directionServer, c.recvSeq)
+	c.recvSeq++
+	return c.aead.Open(nil, nonce, data, nil)
+}
+
+func TestSessionCipher(t *testing.T) {
+	client := newTestClientCipher(t)
+	server, serverKey, err := newSessionCipher(client.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.exchange(t, serverKey)
+
+	// both sides derive the same key
+	for i := 0; i < 3; i++ {
+		msg := []byte(fmt.Sprintf("client message %d", i))
+		data, err := server.open(client.seal(msg))
+		if err != nil || string(data) != string(msg) {
+			t.Fatalf("open client message failed: %v", err)
+		}
+
+		msg = []byte(fmt.Sprintf("server message %d", i))
+		data, err = client.open(server.seal(msg))
+		if err != nil || string(data) != string(msg) {
+			t.Fatalf("open server message failed: %v", err)
+		}
+	}
+
+	// tampered
+	sealed := client.seal([]byte("hello"))
+	sealed[0] ^= 0xff
+	if _, err := server.open(sealed); err == nil {
+		t.Error("tampered data should not be opened")
+	}
+
+	// replayed
+	sealed = client.seal([]byte("hello"))
+	if _, err := server.open(sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.open(sealed); err == nil {
+		t.Error("replayed data should not be opened")
+	}
+
+	// out of order
+	client = newTestClientCipher(t)
+	if server, serverKey, err = newSessionCipher(client.publicKey()); err != nil {
+		t.Fatal(err)
+	}
+	client.exchange(t, serverKey)
+	client.seal([]byte("first"))
+	second := client.seal([]byte("second"))
+	if _, err := server.open(second); err == nil {
+		t.Error("data sealed with later nonce should not be opened first")
+	}
+
+	// sealed data of one direction can not be opened as the other one
+	if _, err := client.open(client.seal([]byte("hello"))); err == nil {
+		t.Error("client data should not be opened as server data")
+	}
+}
+
+func TestSessionCipherInvalidKey(t *testing.T) {
+	keys := []string{
+		"",
+		"not base64",
+		base64.StdEncoding.EncodeToString([]byte("not a point")),
+	}
+	for _, key := range keys {
+		if _, _, err := newSessionCipher(key); err != ErrInvalidPubKey {
+			t.Errorf("expect %v with key %q, got %v", ErrInvalidPubKey, key, err)
+		}
+	}
+}
+
+func TestHandshakeEncrypt(t *testing.T) {
+	app.config.Encrypt = true
+	defer func() { app.config.Encrypt = false }()
+
+	// public key is required
+	a := handshakeAgent()
+	handshake(a, `{}`)
+	if a.status != statusClosed {
+		t.Fatal("handshake without public key should be rejected")
+	}
+
+	client := newTestClientCipher(t)
+	a = handshakeAgent()
+	handshake(a, fmt.Sprintf(`{"sys":{"ecdh":%q}}`, client.publicKey()))
+	if a.status != statusHandshake || a.cipher == nil || !a.protocol().encrypt {
+		t.Fatal("handshake with public key failed")
+	}
+
+	data, ok := a.queue.pop()
+	if !ok {
+		t.Fatal("handshake response not sent")
+	}
+	p, _, err := codec.Default.Unpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := struct {
+		Sys struct {
+			ECDH string `json:"ecdh"`
+		} `json:"sys"`
+	}{}
+	if err := json.Unmarshal(p.Data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	client.exchange(t, resp.Sys.ECDH)
+
+	// packets are not encrypted before handshake ack
+	data, err = a.seal(mustPackData(t, a, []byte("plain")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _, _ := a.codec().Unpack(data); string(p.Data) != "plain" {
+		t.Error("data should not be sealed before handshake ack")
+	}
+	handler.processPacket(a, &packet.Packet{Type: packet.HandshakeAck})
+
+	// sealed request is opened, and its response is sealed
+	m, err := a.codec().Encode(&message.Message{Type: message.Request, ID: 1, Route: "Unknown.Method"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := client.seal(m)
+	handler.processPacket(a, &packet.Packet{Type: packet.Data, Data: sealed})
+	if a.status != statusWorking {
+		t.Fatal("sealed request should be opened")
+	}
+	if data, ok = a.queue.pop(); !ok {
+		t.Fatal("response not sent")
+	}
+	if data, err = a.seal(data); err != nil {
+		t.Fatal(err)
+	}
+	if p, _, err = a.codec().Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := client.open(p.Data)
+	if err != nil {
+		t.Fatalf("open response failed: %v", err)
+	}
+	if r, err := a.codec().Decode(opened); err != nil || r.Type != message.Response || r.ID != 1 {
+		t.Fatalf("wrong response: %v %v", r, err)
+	}
+
+	// replayed request fails to decrypt, and the agent is closed
+	handler.processPacket(a, &packet.Packet{Type: packet.Data, Data: sealed})
+	if a.status != statusClosed {
+		t.Error("agent should be closed when decrypting failed")
+	}
+}
+
+func TestHandshakeSignedKey(t *testing.T) {
+	app.config.Encrypt = true
+	defer func() { app.config.Encrypt = false }()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigningKey(key)
+	defer SetSigningKey(nil)
+
+	client := newTestClientCipher(t)
+	sys := handshakeSys(t, fmt.Sprintf(`{"sys":{"ecdh":%q}}`, client.publicKey()))
+	serverKey, _ := sys["ecdh"].(string)
+	signature, _ := sys["ecdhSig"].(string)
+
+	// client verifies the exchanged keys with the pinned public key
+	server, err := base64.StdEncoding.DecodeString(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(append(server, client.priv.PublicKey().Bytes()...))
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("signature of exchanged keys should be verified")
+	}
+
+	// signature is bound to the client key
+	other := newTestClientCipher(t)
+	digest = sha256.Sum256(append(server, other.priv.PublicKey().Bytes()...))
+	if ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("signature should not be verified with other client key")
+	}
+}
+
+func mustPackData(t *testing.T, a *agent, data []byte) []byte {
+	p, err := a.codec().Pack(&packet.Packet{Type: packet.Data, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
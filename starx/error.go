@@ -0,0 +1,91 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"github.com/chrislonng/starx/cluster/rpc"
+	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/session"
+	"github.com/golang/protobuf/proto"
+)
+
+// Codes of the standard error response
+const (
+	CodeBadRequest    = 400 // request data can not be deserialized, or invalid route
+	CodeRouteNotFound = 404 // handler of the route not found
+	CodeInternal      = 500 // handler returned an untyped error
+)
+
+// Error represents the error responded to client, handler could return it to
+// respond the specified code. Errors are responded as the standard envelope,
+// which is encoded by the configured serializer, the reserved field `error`
+// is always true in an envelope, so client can distinguish the failed
+// response from the normal one, e.g. {"error":true,"code":404,"msg":"route not found"}
+// in JSON, or a protobuf message with the fields: 1 code, 2 msg, 3 error
+type Error struct {
+	Code   int32  `protobuf:"varint,1,opt,name=code,proto3" json:"code"`
+	Msg    string `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg"`
+	Failed bool   `protobuf:"varint,3,opt,name=error,proto3" json:"error"`
+}
+
+func (e Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Reset()         { *e = Error{} }
+func (e *Error) String() string { return proto.CompactTextString(e) }
+func (*Error) ProtoMessage()    {}
+
+// NewError returns an error with code and message
+func NewError(code int, msg string) *Error {
+	return &Error{Code: int32(code), Msg: msg}
+}
+
+// toError converts err to the error envelope, error returned from backend
+// server keeps its code, other errors are treated as internal errors
+func toError(err error) *Error {
+	e := &Error{Code: CodeInternal, Msg: err.Error(), Failed: true}
+	switch t := err.(type) {
+	case *Error:
+		e.Code = t.Code
+	case Error:
+		e.Code = t.Code
+	case *rpc.CodedError:
+		e.Code = int32(t.Code)
+	}
+	return e
+}
+
+// responseError responds the error envelope to client when the session is
+// handling a request, notify has no response, so the error is only logged
+func responseError(session *session.Session, err error) {
+	if session.LastID <= 0 {
+		return
+	}
+
+	data, err := serializeOrRaw(toError(err))
+	if err != nil {
+		return
+	}
+	if err := transporter.response(session, data); err != nil {
+		log.Errorf(err.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package starx
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/chrislonng/starx/cluster/rpc"
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/serialize"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/serialize/protobuf"
+)
+
+func TestToError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int32
+		msg  string
+	}{
+		{Error{Code: 403, Msg: "forbidden"}, 403, "forbidden"},
+		{NewError(404, "route not found"), 404, "route not found"},
+		{&rpc.CodedError{Code: 401, Msg: "unauthorized"}, 401, "unauthorized"},
+		{rpc.ServerError("backend error"), CodeInternal, "backend error"},
+		{errors.New("error"), CodeInternal, "error"},
+	}
+
+	for _, c := range cases {
+		e := toError(c.err)
+		if !e.Failed || e.Code != c.code || e.Msg != c.msg {
+			t.Errorf("expect %d %s, got %d %s", c.code, c.msg, e.Code, e.Msg)
+		}
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	serializers := []serialize.Serializer{json.NewSerializer(), protobuf.NewSerializer()}
+	for _, seri := range serializers {
+		SetSerializer(seri)
+
+		conn, _ := net.Pipe()
+		a := newAgent(conn)
+
+		// unknown route
+		handler.processMessage(a.session, &message.Message{
+			Type:  message.Request,
+			ID:    10,
+			Route: "Unknown.Method",
+		})
+
+		data, ok := a.queue.pop()
+		if !ok {
+			t.Fatal("error response not sent")
+		}
+
+		p, _, err := codec.Default.Unpack(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := codec.Default.Decode(p.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != message.Response || m.ID != 10 {
+			t.Fatalf("wrong response: %s", m)
+		}
+
+		e := &Error{}
+		if err := seri.Deserialize(m.Data, e); err != nil {
+			t.Fatal(err)
+		}
+		if !e.Failed || e.Code != CodeRouteNotFound {
+			t.Errorf("expect error code %d, got %+v", CodeRouteNotFound, e)
+		}
+
+		// notify has no response
+		handler.processMessage(a.session, &message.Message{
+			Type:  message.Notify,
+			Route: "Unknown.Method",
+		})
+		if a.queueDepth() != 0 {
+			t.Error("notify should not be responded")
+		}
+	}
+}
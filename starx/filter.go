@@ -0,0 +1,60 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"github.com/chrislonng/starx/route"
+	"github.com/chrislonng/starx/session"
+)
+
+// BeforeFilter will be called before handler executed, with the session, the
+// decoded route and message, the handler will not be executed when filter
+// returns error, and the error will be treated as the handler result
+type BeforeFilter func(s *session.Session, r *route.Route, msg interface{}) error
+
+// AfterFilter will be called after handler executed, or a before filter
+// returned error, resp and err are the handler result, resp is nil when the
+// handler has no response or failed, returned error replaces the error of the
+// result, e.g. nil to swallow the error
+type AfterFilter func(s *session.Session, r *route.Route, msg interface{}, resp interface{}, err error) error
+
+// filterHandler calls handler between before filters and after filters, call
+// will be skipped when any before filter returns error
+func filterHandler(s *session.Session, r *route.Route, msg interface{}, call func() (interface{}, error)) (interface{}, error) {
+	var (
+		resp interface{}
+		err  error
+	)
+	for _, f := range env.beforeFilters {
+		if err = f(s, r, msg); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		resp, err = call()
+	}
+
+	for _, f := range env.afterFilters {
+		err = f(s, r, msg, resp, err)
+	}
+	return resp, err
+}
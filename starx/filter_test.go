@@ -0,0 +1,74 @@
+package starx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chrislonng/starx/route"
+	"github.com/chrislonng/starx/session"
+)
+
+func TestFilterHandler(t *testing.T) {
+	defer func(before []BeforeFilter, after []AfterFilter) {
+		env.beforeFilters, env.afterFilters = before, after
+	}(env.beforeFilters, env.afterFilters)
+	env.beforeFilters, env.afterFilters = nil, nil
+
+	errDenied := errors.New("denied")
+	var trace []string
+	UseBefore(func(s *session.Session, r *route.Route, msg interface{}) error {
+		trace = append(trace, "before1")
+		if s.Uid == 0 {
+			return errDenied
+		}
+		return nil
+	}, func(s *session.Session, r *route.Route, msg interface{}) error {
+		trace = append(trace, "before2")
+		return nil
+	})
+	var result interface{}
+	UseAfter(func(s *session.Session, r *route.Route, msg interface{}, resp interface{}, err error) error {
+		trace = append(trace, "after")
+		result = resp
+		return err
+	})
+
+	r := route.NewRoute("test", "TestComp", "HandleJson")
+	s := session.New(nil)
+	call := func() (interface{}, error) {
+		trace = append(trace, "handler")
+		return "reply", nil
+	}
+
+	// short-circuit by before filter
+	if _, err := filterHandler(s, r, nil, call); err != errDenied {
+		t.Fatalf("expect errDenied, got %v", err)
+	}
+	if len(trace) != 2 || trace[0] != "before1" || trace[1] != "after" {
+		t.Fatalf("wrong filter trace: %v", trace)
+	}
+	if result != nil {
+		t.Fatalf("expect no response, got %v", result)
+	}
+
+	trace = nil
+	s.Uid = 1
+	resp, err := filterHandler(s, r, nil, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace) != 4 || trace[2] != "handler" || trace[3] != "after" {
+		t.Fatalf("wrong filter trace: %v", trace)
+	}
+	if resp != "reply" || result != "reply" {
+		t.Fatalf("after filter should receive response, got %v", result)
+	}
+
+	// after filter replaces handler result
+	UseAfter(func(s *session.Session, r *route.Route, msg interface{}, resp interface{}, err error) error {
+		return nil
+	})
+	if _, err := filterHandler(s, r, nil, func() (interface{}, error) { return nil, errDenied }); err != nil {
+		t.Fatalf("error should be swallowed, got %v", err)
+	}
+}
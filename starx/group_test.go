@@ -1,10 +1,17 @@
 package starx
 
 import (
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/lonnng/starx/session"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/session"
 )
 
 func TestChannel_Add(t *testing.T) {
@@ -50,3 +57,102 @@ func TestChannel_Add(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// benchmarkGroupBroadcast broadcasts to clients connected via loopback tcp,
+// and waits for all clients received all pushed packets
+func benchmarkGroupBroadcast(b *testing.B, coalesce int, interval time.Duration) {
+	const (
+		clients = 64
+		route   = "bench.broadcast"
+	)
+
+	defer func(n int, d time.Duration) {
+		env.maxCoalescePackets = n
+		env.flushInterval = d
+	}(env.maxCoalescePackets, env.flushInterval)
+	env.maxCoalescePackets = coalesce
+	env.flushInterval = interval
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	g := NewGroup("bench_broadcast")
+	conns := make([]net.Conn, 0, clients)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	for i := 0; i < clients; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns = append(conns, c)
+
+		sc, err := l.Accept()
+		if err != nil {
+			b.Fatal(err)
+		}
+		go handler.handle(sc)
+
+		s := agentSession(sc)
+		s.Bind(int64(i + 1))
+		g.Add(s)
+	}
+
+	data := make([]byte, 64)
+	ep, err := packMessage(g.Member(1), &message.Message{Type: message.Push, Route: route, Data: data})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(ep) * clients))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c net.Conn) {
+			defer wg.Done()
+			io.CopyN(ioutil.Discard, c, int64(len(ep)*b.N))
+		}(c)
+	}
+
+	for i := 0; i < b.N; i++ {
+		g.Broadcast(route, data)
+	}
+	wg.Wait()
+}
+
+// agentSession waits for the agent of the socket created, and returns its session
+func agentSession(conn net.Conn) *session.Session {
+	for {
+		transporter.RLock()
+		for _, a := range transporter.agents {
+			if a.socket == conn {
+				transporter.RUnlock()
+				return a.session
+			}
+		}
+		transporter.RUnlock()
+		runtime.Gosched()
+	}
+}
+
+func BenchmarkGroup_Broadcast(b *testing.B) {
+	b.Run("NoCoalesce", func(b *testing.B) {
+		benchmarkGroupBroadcast(b, 1, 0)
+	})
+	b.Run("Coalesce", func(b *testing.B) {
+		benchmarkGroupBroadcast(b, 64, 0)
+	})
+	b.Run("FlushInterval1ms", func(b *testing.B) {
+		benchmarkGroupBroadcast(b, 64, time.Millisecond)
+	})
+}
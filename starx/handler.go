@@ -25,14 +25,17 @@ import (
 	"errors"
 	"net"
 	"reflect"
+	"time"
 
 	"github.com/chrislonng/starx/cluster"
 	"github.com/chrislonng/starx/cluster/rpc"
+	"github.com/chrislonng/starx/codec"
 	"github.com/chrislonng/starx/component"
 	"github.com/chrislonng/starx/log"
 	"github.com/chrislonng/starx/message"
 	"github.com/chrislonng/starx/packet"
 	"github.com/chrislonng/starx/route"
+	"github.com/chrislonng/starx/service"
 	"github.com/chrislonng/starx/session"
 )
 
@@ -42,6 +45,9 @@ const (
 	packetBufferSize = 256
 )
 
+// Max duration waiting for the kick packet flushed
+const kickFlushTimeout = 3 * time.Second
+
 var handler = newHandlerService()
 
 type handlerService struct {
@@ -86,31 +92,75 @@ func (hs *handlerService) handle(conn net.Conn) {
 	defer func() {
 		conn.Close()
 		//remove session from sessions
-		if nil != agent{
-			FindConnLostCallBack(agent.session.BelongToComponent)(agent.session.ID)
+		if nil != agent && !transporter.isSuspended(agent) {
+			FindConnLostCallBack(agent.Session().BelongToComponent)(agent.Session().ID)
 		}
 	}()
 
-	// register new session when new connection connected in
-	agent = transporter.createAgent(conn)
+	// register new session when new connection connected in, the
+	// connection will be rejected when admission control not passed
+	var err error
+	agent, err = transporter.createAgent(conn)
+	if err != nil {
+		log.Infof("Connection rejected, Remote=%s, Error=%s", conn.RemoteAddr(), err.Error())
+		hs.rejectConn(conn, err)
+		return
+	}
 	log.Debugf("New session established: %s", agent.String())
 
 	// all user logic will be handled in single goroutine
 	// synchronized in below routine
 	go func() {
+		w := newPacketWriter(agent)
+
+		// write all pending packets, returns false when agent closed
+		flush := func() bool {
+			if err := w.flush(); err != nil {
+				log.Error(err)
+				agent.lost()
+				return false
+			}
+
+			// kick packet has been flushed, disconnect
+			if w.kick {
+				agent.Close()
+				return false
+			}
+			return true
+		}
+
+		// write all queued packets in order, packets could be delayed for
+		// coalescing, returns false when agent closed
+		write := func() bool {
+			for {
+				if err := w.drain(); err != nil {
+					log.Error(err)
+					agent.Close()
+					return false
+				}
+				if len(w.pending) == 0 || !w.ready(env.flushInterval) {
+					return true
+				}
+				if !flush() {
+					return false
+				}
+			}
+		}
+
 		for {
 			select {
 			case p, ok := <-agent.recvBuffer:
 				if ok && p != nil {
 					hs.processPacket(agent, p)
+					packet.Release(p)
 				}
-			case m, ok := <-agent.sendBuffer:
-				if ok && m != nil {
-					_, err := agent.socket.Write(m)
-					if err != nil {
-						log.Error(err)
-						agent.Close()
-					}
+			case <-agent.queue.ready:
+				if !write() {
+					return
+				}
+			case <-w.C:
+				if !flush() {
+					return
 				}
 			case <-agent.die:
 				return
@@ -121,76 +171,338 @@ func (hs *handlerService) handle(conn net.Conn) {
 		}
 	}()
 
-	tmp := make([]byte, 0) // save truncated data
-	buf := make([]byte, 2048)
+	// read and decode packets, codec could be changed after handshake, so
+	// the framing should be loaded for every packet
+	dec := packet.NewDecoder(conn, app.config.MaxPacketSize)
 	for {
-		n, err := conn.Read(buf)
+		// idle connection will be closed when nothing received in two
+		// heartbeat intervals
+		if interval := env.heartbeatInternal; interval > 0 {
+			conn.SetReadDeadline(time.Now().Add(2 * interval))
+		}
+
+		p, err := dec.Decode(agent.codec())
+		if err == packet.ErrPacketTooLarge {
+			service.Stats.IncrementOversizedPackets()
+			log.Infof("Packet too large, session will be kicked, Id=%d, Remote=%s", agent.ID(), conn.RemoteAddr())
+			hs.kickOversized(agent)
+			break
+		}
 		if err != nil {
 			log.Errorf("Read message error: %s, session will be closed immediately", err.Error())
-			agent.Close()
+			agent.lost()
 			break // break read packet loop
 		}
-		tmp = append(tmp, buf[:n]...)
+		agent.heartbeat()
+
+		// agent may be closed by logic goroutine, e.g. data packet received
+		// before handshake, stop reading when it happened
+		select {
+		case agent.recvBuffer <- p:
+		case <-agent.die:
+			packet.Release(p)
+			return
+		}
+	}
+}
 
-		// save decoded packet
-		var p *packet.Packet
-		for len(tmp) >= packet.HeadLength {
-			p, tmp, err = packet.Unpack(tmp)
-			if err != nil {
-				agent.Close()
-				break
-			}
+// kickOversized kicks the agent which sent an oversized packet, and waits the
+// kick packet flushed by logic goroutine before the connection closed
+func (hs *handlerService) kickOversized(agent *agent) {
+	if err := agent.Kick(agent.Session(), []byte(packet.ErrPacketTooLarge.Error())); err != nil {
+		agent.Close()
+		return
+	}
 
-			if p == nil {
-				break
-			}
-			agent.recvBuffer <- p
-		}
+	select {
+	case <-agent.die:
+	case <-time.After(kickFlushTimeout):
+		agent.Close()
 	}
 }
 
-func (hs *handlerService) processPacket(a *agent, p *packet.Packet) {
-	switch p.Type {
-	case packet.Handshake:
-		a.status = statusHandshake
-		data, err := json.Marshal(map[string]interface{}{
-			"code": 200,
-			"sys":  map[string]float64{"heartbeat": env.heartbeatInternal.Seconds()},
-		})
-		if err != nil {
-			log.Infof(err.Error())
+// Handshake response code, compatible with pomelo client
+const (
+	handshakeOK        = 200
+	handshakeFail      = 500
+	handshakeOldClient = 501
+)
+
+// ErrOldClient should be returned by handshake validator when the client
+// version is not fulfilled, client will receive the `old client` code
+var ErrOldClient = errors.New("client version not fulfill")
+
+// handshake parse client handshake body, and response heartbeat internal and
+// route dictionary to client, dictionary will be omitted when client has the
+// same version dictionary already
+func (hs *handlerService) handshake(a *agent, data []byte) {
+	var body map[string]interface{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			log.Errorf("invalid handshake body: %s", err.Error())
+			hs.rejectHandshake(a, handshakeFail, err)
+			return
+		}
+	}
+
+	// wire codec which client supported, in priority order
+	c := codec.Negotiate(clientCodecs(clientSys(body)["codec"]))
+	compress, _ := clientSys(body)["compress"].(bool)
+
+	// client information reported in `sys` field, validator could
+	// store more information from the handshake body
+	for k, v := range clientSys(body) {
+		a.Session().ClientInfo[k] = v
+	}
+
+	if validator := env.handshakeValidator; validator != nil {
+		if err := validator(a.Session(), body); err != nil {
+			log.Infof("Session handshake rejected Id=%d, Remote=%s, Error=%s", a.ID(), a.socket.RemoteAddr(), err.Error())
+			code := handshakeFail
+			if err == ErrOldClient {
+				code = handshakeOldClient
+			}
+			hs.rejectHandshake(a, code, err)
+			return
 		}
+	}
 
-		rp := &packet.Packet{
-			Type:   packet.Handshake,
-			Length: len(data),
-			Data:   data,
+	// exchange key when encryption enabled, data packets will be encrypted
+	// after handshake ack
+	var serverKey, signature string
+	if app.config.Encrypt {
+		clientKey, ok := clientSys(body)["ecdh"].(string)
+		if !ok {
+			hs.rejectHandshake(a, handshakeFail, ErrEncryptRequired)
+			return
 		}
 
-		resp, err := rp.Pack()
+		c, key, err := newSessionCipher(clientKey)
 		if err != nil {
 			log.Errorf(err.Error())
-			a.Close()
+			hs.rejectHandshake(a, handshakeFail, err)
+			return
+		}
+		a.cipher = c
+		serverKey = key
+
+		// sign the exchanged keys, clients verify it with the pinned
+		// public key
+		if env.signingKey != nil {
+			if signature, err = signExchange(env.signingKey, serverKey, clientKey); err != nil {
+				log.Errorf(err.Error())
+				hs.rejectHandshake(a, handshakeFail, err)
+				return
+			}
 		}
+	}
 
-		if err := a.Send(resp); err != nil {
+	// issue a new resume token in every handshake
+	if env.resumeGrace > 0 {
+		token, err := newResumeToken()
+		if err != nil {
 			log.Errorf(err.Error())
+			hs.rejectHandshake(a, handshakeFail, err)
+			return
+		}
+		a.resumeToken = token
+	}
+
+	// reattach to the previous session when client presents a resume token,
+	// the new session will be used when the token is invalid or expired,
+	// resuming happens after all checks passed, so a rejected handshake does
+	// not destroy the previous session
+	if token, ok := clientSys(body)["resume"].(string); ok && env.resumeGrace > 0 {
+		info := a.Session().ClientInfo
+		if transporter.resume(a, token) {
+			for k, v := range info {
+				a.Session().ClientInfo[k] = v
+			}
+		} else {
+			log.Debugf("Invalid resume token, Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+		}
+	}
+
+	a.setStatus(statusHandshake)
+
+	version := message.DictVersion()
+	sys := map[string]interface{}{
+		"heartbeat":   env.heartbeatInternal.Seconds(),
+		"useDict":     true,
+		"dictVersion": version,
+		"codec":       c.Name(),
+		"compress":    compress,
+	}
+	if serverKey != "" {
+		sys["ecdh"] = serverKey
+	}
+	if signature != "" {
+		sys["ecdhSig"] = signature
+	}
+	if a.resumeToken != "" {
+		sys["resume"] = a.resumeToken
+	}
+	if clientSys(body)["dictVersion"] != version {
+		sys["dict"] = message.Dict()
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"code": handshakeOK,
+		"sys":  sys,
+	})
+	if err != nil {
+		log.Errorf(err.Error())
+		a.Close()
+		return
+	}
+
+	p, err := packet.Pack(&packet.Packet{Type: packet.Handshake, Data: resp})
+	if err != nil {
+		log.Errorf(err.Error())
+		a.Close()
+		return
+	}
+
+	if err := a.Send(p); err != nil {
+		log.Errorf(err.Error())
+		a.Close()
+		return
+	}
+
+	// handshake response has been packed with the default codec, following
+	// packets will be packed with the negotiated codec
+	a.setProtocol(wireProtocol{codec: c, compress: compress, encrypt: a.cipher != nil})
+	log.Debugf("Session handshake Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+}
+
+// rejectHandshake response the error code to client and close the agent, it
+// is called in agent logic goroutine, so write socket directly to make sure
+// the response has been flushed before closing
+func (hs *handlerService) rejectHandshake(a *agent, code int, reason error) {
+	defer a.Close()
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"code": code,
+		"msg":  reason.Error(),
+	})
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	p, err := packet.Pack(&packet.Packet{Type: packet.Handshake, Data: resp})
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	if _, err := a.socket.Write(p); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+// rejectConn kicks the connection which rejected by admission control, the
+// connection will be closed by caller
+func (hs *handlerService) rejectConn(conn net.Conn, reason error) {
+	p, err := packet.Pack(&packet.Packet{Type: packet.Kick, Data: []byte(reason.Error())})
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(kickFlushTimeout))
+	if _, err := conn.Write(p); err != nil {
+		log.Errorf(err.Error())
+	}
+}
+
+// clientCodecs returns codec names that client supported, `codec` field
+// could be a string or an array of string
+func clientCodecs(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var names []string
+		for _, name := range v {
+			if n, ok := name.(string); ok {
+				names = append(names, n)
+			}
+		}
+		return names
+	default:
+		return nil
+	}
+}
+
+// clientSys returns `sys` field of client handshake body
+func clientSys(body map[string]interface{}) map[string]interface{} {
+	sys, ok := body["sys"].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}
+	}
+	return sys
+}
+
+func isKickPacket(data []byte) bool {
+	return len(data) > 0 && packet.PacketType(data[0]) == packet.Kick
+}
+
+func (hs *handlerService) processPacket(a *agent, p *packet.Packet) {
+	switch p.Type {
+	case packet.Handshake:
+		// handshake only once, the second handshake would orphan the
+		// session and reset the cipher
+		if a.loadStatus() != statusStart {
+			log.Infof("duplicated handshake Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
 			a.Close()
+			return
 		}
-		log.Debugf("Session handshake Id=%d, Remote=%s", a.id, a.socket.RemoteAddr())
+		hs.handshake(a, p.Data)
 	case packet.HandshakeAck:
-		a.status = statusWorking
-		log.Debugf("Receive handshake ACK Id=%d, Remote=%s", a.id, a.socket.RemoteAddr())
+		if a.loadStatus() != statusHandshake {
+			log.Infof("handshake ack before handshake Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+			a.Close()
+			return
+		}
+		a.setStatus(statusWorking)
+		if a.cipher != nil {
+			a.cipher.active = true
+		}
+		log.Debugf("Receive handshake ACK Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+
+		// replay unacked reliable pushes, e.g. session resumed
+		transporter.replay(a)
 	case packet.Data:
-		m, err := message.Decode(p.Data)
+		if a.loadStatus() < statusWorking {
+			log.Infof("data packet before handshake Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+			a.Close()
+			return
+		}
+		data := p.Data
+		if a.cipher != nil {
+			var err error
+			if data, err = a.cipher.open(data); err != nil {
+				log.Errorf("decrypt packet failed Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+				a.Close()
+				return
+			}
+		}
+
+		m, err := a.codec().Decode(data)
 		if err != nil {
 			log.Errorf(err.Error())
 			return
 		}
-		hs.processMessage(a.session, m)
-		fallthrough
+		if m.Deflate && !a.protocol().compress {
+			log.Errorf("deflated message without compression negotiated Id=%d, Remote=%s", a.ID(), a.socket.RemoteAddr())
+			return
+		}
+		beginCall()
+		hs.processMessage(a.Session(), m)
+		endCall()
 	case packet.Heartbeat:
-		go a.heartbeat()
+		// receiving time has been recorded in network goroutine
 	default:
 		log.Infof("invalid packet type")
 		a.Close()
@@ -201,14 +513,20 @@ func (hs *handlerService) processMessage(session *session.Session, msg *message.
 	defer func() {
 		if err := recover(); err != nil {
 			log.Tracef("processMessage Error: %+v", err)
+			responseError(session, NewError(CodeInternal, "internal error"))
 		}
 	}()
 
 	switch msg.Type {
 	case message.Request:
 		session.LastID = msg.ID
+		session.LastRoute = msg.Route
 	case message.Notify:
 		session.LastID = 0
+		session.LastRoute = ""
+	case message.Ack:
+		transporter.ack(session, msg.ID)
+		return
 	default:
 		log.Errorf("invalid message type")
 		return
@@ -217,6 +535,7 @@ func (hs *handlerService) processMessage(session *session.Session, msg *message.
 	r, err := route.Decode(msg.Route)
 	if err != nil {
 		log.Errorf(err.Error())
+		responseError(session, NewError(CodeBadRequest, err.Error()))
 		return
 	}
 
@@ -238,43 +557,105 @@ func (hs *handlerService) localProcess(session *session.Session, route *route.Ro
 	s, ok := hs.serviceMap[route.Service]
 	if !ok || s == nil {
 		log.Infof("handler: service: " + route.Service + " not found")
+		responseError(session, NewError(CodeRouteNotFound, "route not found"))
 		return
 	}
 
 	m, ok := s.HandlerMethods[route.Method]
 	if !ok || m == nil {
 		log.Infof("handler: " + route.Service + " does not contain method: " + route.Method)
+		responseError(session, NewError(CodeRouteNotFound, "route not found"))
+		return
+	}
+
+	// notify has no response, so it can not be handled by the handler which
+	// returns response
+	if m.Reply && session.LastID <= 0 {
+		log.Errorf("handler: %s.%s returns response, can not handle notify", route.Service, route.Method)
 		return
 	}
 
 	var data interface{}
 	if m.Raw {
-		data = msg.Data
+		// packet data will be reused after processed, handler may retain it
+		data = append([]byte(nil), msg.Data...)
 	} else {
 		data = reflect.New(m.Type.Elem()).Interface()
 		err := serializer.Deserialize(msg.Data, data)
 		if err != nil {
 			log.Errorf("deserialize error: %s", err.Error())
+			responseError(session, NewError(CodeBadRequest, "deserialize error: "+err.Error()))
 			return
 		}
 	}
 
 	log.Debugf("Uid=%d, Message={%s}, Data=%+v", session.Uid, msg.String(), data)
 
-	ret := m.Method.Func.Call([]reflect.Value{s.Rcvr, reflect.ValueOf(session), reflect.ValueOf(data)})
-	if len(ret) > 0 {
-		err := ret[0].Interface()
-		if err != nil {
-			log.Errorf(err.(error).Error())
+	resp, err := filterHandler(session, route, data, func() (interface{}, error) {
+		ret := m.Method.Func.Call([]reflect.Value{s.Rcvr, reflect.ValueOf(session), reflect.ValueOf(data)})
+		return handlerResult(m, ret)
+	})
+	if err != nil {
+		log.Errorf(err.Error())
+		responseError(session, err)
+		return
+	}
+
+	if resp = replyOf(m, resp); resp != nil {
+		if err := session.Response(resp); err != nil {
+			log.Errorf(err.Error())
 		}
 	}
 }
 
-// current message handle in remote server
+// replyOf returns the response to client, the handler returns response may
+// return neither response nor error, e.g. an after filter swallows the error,
+// an empty response is sent for it, client waits for the request forever
+// otherwise
+func replyOf(m *component.HandlerMethod, resp interface{}) interface{} {
+	if resp == nil && m.Reply {
+		return []byte{}
+	}
+	return resp
+}
+
+// handlerResult returns the response and error returned from handler, the
+// response is nil when handler returns error only
+func handlerResult(m *component.HandlerMethod, ret []reflect.Value) (interface{}, error) {
+	if err := ret[len(ret)-1].Interface(); err != nil {
+		return nil, err.(error)
+	}
+	if m.Reply && !ret[0].IsNil() {
+		return ret[0].Interface(), nil
+	}
+	return nil, nil
+}
+
+// current message handle in remote server, error returned from backend
+// server will be responded to client
 func (hs *handlerService) remoteProcess(session *session.Session, route *route.Route, msg *message.Message) {
-	if _, err := cluster.Call(rpc.Sys, route, session, msg.Data); err != nil {
+	// backend server rejects notify routed to the handler returns response
+	kind := rpc.Sys
+	if msg.Type == message.Notify {
+		kind = rpc.SysNotify
+	}
+	if _, err := cluster.Call(kind, route, session, msg.Data); err != nil {
 		log.Errorf(err.Error())
+		responseError(session, err)
+	}
+}
+
+// All routes of registered handler, both `Service.Method` and
+// `ServerType.Service.Method` formats are contained
+func (hs *handlerService) routes() []string {
+	var routes []string
+	for sname, s := range hs.serviceMap {
+		for mname := range s.HandlerMethods {
+			r := route.NewRoute(app.config.Type, sname, mname)
+			routes = append(routes, r.Service+"."+r.Method, r.String())
+		}
 	}
+	return routes
 }
 
 func (hs *handlerService) dumpServiceMap() {
@@ -1,17 +1,27 @@
 package starx
 
 import (
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"reflect"
 	"testing"
-
+	"time"
+
+	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/component"
+	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/serialize/protobuf"
+	"github.com/chrislonng/starx/service"
+	"github.com/chrislonng/starx/session"
 	"github.com/golang/protobuf/proto"
-	"github.com/lonnng/starx/cluster"
-	"github.com/lonnng/starx/component"
-	"github.com/lonnng/starx/log"
-	"github.com/lonnng/starx/message"
-	"github.com/lonnng/starx/serialize/json"
-	"github.com/lonnng/starx/serialize/protobuf"
-	"github.com/lonnng/starx/session"
 )
 
 func TestMain(m *testing.M) {
@@ -130,6 +140,15 @@ func (t *TestComp) HandleProto(s *session.Session, m *ProtoMessage) error {
 	return nil
 }
 
+func (t *TestComp) HandleReply(s *session.Session, m *JsonMessage) (*JsonMessage, error) {
+	return &JsonMessage{Code: m.Code + 1, Data: m.Data}, nil
+}
+
+// HandleEmptyReply returns neither response nor error
+func (t *TestComp) HandleEmptyReply(s *session.Session, m *JsonMessage) (*JsonMessage, error) {
+	return nil, nil
+}
+
 func TestHandlerCallJSON(t *testing.T) {
 	SetSerializer(json.NewSerializer())
 	handler.register(&TestComp{})
@@ -170,6 +189,355 @@ func TestHandlerCallProtobuf(t *testing.T) {
 	handler.processMessage(s, msg)
 }
 
+func TestHandlerCallReply(t *testing.T) {
+	SetSerializer(json.NewSerializer())
+	handler.register(&TestComp{})
+
+	data, err := serializeOrRaw(JsonMessage{Code: 1, Data: "hello world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+
+	msg := message.New()
+	msg.Route = "TestComp.HandleReply"
+	msg.Type = message.Request
+	msg.ID = 1
+	msg.Data = data
+	handler.processMessage(a.session, msg)
+
+	resp, ok := a.queue.pop()
+	if !ok {
+		t.Fatal("response not sent")
+	}
+	p, _, err := codec.Default.Unpack(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := codec.Default.Decode(p.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := &JsonMessage{}
+	if err := serializer.Deserialize(m.Data, reply); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != message.Response || m.ID != 1 || reply.Code != 2 {
+		t.Errorf("wrong response: %s, %+v", m, reply)
+	}
+
+	// empty response is sent when handler returns nothing
+	msg.Route = "TestComp.HandleEmptyReply"
+	msg.ID = 2
+	handler.processMessage(a.session, msg)
+	if resp, ok = a.queue.pop(); !ok {
+		t.Fatal("empty response not sent")
+	}
+	if p, _, err = codec.Default.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = codec.Default.Decode(p.Data); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != message.Response || m.ID != 2 || len(m.Data) != 0 {
+		t.Errorf("wrong empty response: %s", m)
+	}
+
+	// handler returns response can not handle notify
+	msg.Type = message.Notify
+	msg.ID = 0
+	handler.processMessage(a.session, msg)
+	if a.queueDepth() != 0 {
+		t.Error("notify should not be responded")
+	}
+}
+
+// serveConn serves a pipe connection in handler, done will be closed when
+// the server side connection handling finished
+func serveConn() (client net.Conn, done chan struct{}) {
+	client, server := net.Pipe()
+	done = make(chan struct{})
+	go func() {
+		handler.handle(server)
+		close(done)
+	}()
+	return client, done
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestHandlerDataBeforeHandshake(t *testing.T) {
+	data, err := packet.Pack(&packet.Packet{Type: packet.Data, Data: []byte("flood")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flood data packets, read loop should stop when agent has been closed
+	// by logic goroutine, instead of delivering to closed receive buffer
+	for i := 0; i < 200; i++ {
+		client, done := serveConn()
+		go func() {
+			for {
+				if _, err := client.Write(data); err != nil {
+					return
+				}
+			}
+		}()
+		waitDone(t, done)
+		client.Close()
+	}
+}
+
+func TestHandlerOversizedPacket(t *testing.T) {
+	defer func(n int) { app.config.MaxPacketSize = n }(app.config.MaxPacketSize)
+	app.config.MaxPacketSize = 64
+
+	before := service.Stats.OversizedPackets()
+	data, err := packet.Pack(&packet.Packet{Type: packet.Handshake, Data: make([]byte, 128)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client is kicked with the reason, and the connection is closed
+	client, done := serveConn()
+	defer client.Close()
+	go client.Write(data)
+
+	p, err := packet.NewDecoder(client, 0).Decode(codec.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != packet.Kick || string(p.Data) != packet.ErrPacketTooLarge.Error() {
+		t.Fatalf("expect kick packet, got %v", p)
+	}
+	waitDone(t, done)
+	if service.Stats.OversizedPackets() != before+1 {
+		t.Error("oversized packet should be counted")
+	}
+}
+
+// handshakeAgent creates an agent whose written data is discarded
+func handshakeAgent() *agent {
+	client, server := net.Pipe()
+	go io.Copy(ioutil.Discard, client)
+	return newAgent(server)
+}
+
+func handshake(a *agent, body string) {
+	handler.processPacket(a, &packet.Packet{Type: packet.Handshake, Data: []byte(body)})
+}
+
+func TestHandshakeOnce(t *testing.T) {
+	a := handshakeAgent()
+	handshake(a, `{}`)
+	if a.status != statusHandshake {
+		t.Fatalf("wrong status after handshake: %d", a.status)
+	}
+
+	// the second handshake is refused
+	handshake(a, `{}`)
+	if a.status != statusClosed {
+		t.Errorf("agent should be closed on duplicated handshake")
+	}
+
+	// handshake is refused after working too, which would reset the cipher
+	a = handshakeAgent()
+	handshake(a, `{}`)
+	handler.processPacket(a, &packet.Packet{Type: packet.HandshakeAck})
+	if a.status != statusWorking {
+		t.Fatalf("wrong status after handshake ack: %d", a.status)
+	}
+	handshake(a, `{}`)
+	if a.status != statusClosed {
+		t.Errorf("agent should be closed on handshake after working")
+	}
+}
+
+// handshakeSys handshakes with body, and returns the sys of the handshake
+// response queued to agent
+func handshakeSys(t *testing.T, body string) map[string]interface{} {
+	a := handshakeAgent()
+	defer a.Close()
+	handshake(a, body)
+
+	data, ok := a.queue.pop()
+	if !ok {
+		t.Fatal("handshake response not sent")
+	}
+	p, _, err := codec.Default.Unpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := struct {
+		Code int                    `json:"code"`
+		Sys  map[string]interface{} `json:"sys"`
+	}{}
+	if err := encjson.Unmarshal(p.Data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Code != handshakeOK {
+		t.Fatalf("handshake failed with code %d", r.Code)
+	}
+	return r.Sys
+}
+
+func TestHandshakeDict(t *testing.T) {
+	message.AppendDict([]string{"test.handshake.dict"})
+	version := message.DictVersion()
+
+	// dictionary is sent when client has no or stale dictionary
+	for _, body := range []string{`{}`, `{"sys":{"dictVersion":"stale"}}`} {
+		sys := handshakeSys(t, body)
+		dict, ok := sys["dict"].(map[string]interface{})
+		if !ok || dict["test.handshake.dict"] == nil {
+			t.Errorf("dictionary should be sent with body %s, got %v", body, sys)
+		}
+		if sys["useDict"] != true || sys["dictVersion"] != version {
+			t.Errorf("wrong dictionary options with body %s: %v", body, sys)
+		}
+	}
+
+	// dictionary is omitted when client has the same version
+	body := fmt.Sprintf(`{"sys":{"dictVersion":%q}}`, version)
+	sys := handshakeSys(t, body)
+	if _, ok := sys["dict"]; ok {
+		t.Error("dictionary should be omitted when client has the same version")
+	}
+	if sys["useDict"] != true || sys["dictVersion"] != version {
+		t.Errorf("wrong dictionary options with body %s: %v", body, sys)
+	}
+}
+
+// rejectedHandshake handshakes with body, and returns the code and message
+// of the handshake response which written to client directly
+func rejectedHandshake(t *testing.T, body string) (a *agent, code int, msg string) {
+	client, server := net.Pipe()
+	defer client.Close()
+	a = newAgent(server)
+
+	resp := make(chan *packet.Packet, 1)
+	go func() {
+		p, err := packet.NewDecoder(client, 0).Decode(codec.Default)
+		if err != nil {
+			close(resp)
+			return
+		}
+		resp <- p
+	}()
+	handshake(a, body)
+
+	var p *packet.Packet
+	select {
+	case p = <-resp:
+	case <-time.After(time.Second):
+		t.Fatal("handshake response not written")
+	}
+	if p == nil || p.Type != packet.Handshake {
+		t.Fatalf("wrong handshake response: %v", p)
+	}
+	r := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+	if err := encjson.Unmarshal(p.Data, &r); err != nil {
+		t.Fatal(err)
+	}
+	return a, r.Code, r.Msg
+}
+
+func TestHandshakeValidator(t *testing.T) {
+	defer SetHandshakeValidator(nil)
+
+	errBanned := errors.New("banned")
+	calls := 0
+	SetHandshakeValidator(func(s *session.Session, body map[string]interface{}) error {
+		calls++
+		// sys field has been populated to client info
+		if s.ClientInfo["version"] != "1.0.0" {
+			return ErrOldClient
+		}
+		user, _ := body["user"].(map[string]interface{})
+		if user["name"] == "banned" {
+			return errBanned
+		}
+		s.ClientInfo["name"] = user["name"]
+		return nil
+	})
+
+	// accepted
+	a := handshakeAgent()
+	handshake(a, `{"sys":{"version":"1.0.0","type":"js"},"user":{"name":"test"}}`)
+	if a.status != statusHandshake {
+		t.Fatalf("handshake should be accepted, status: %d", a.status)
+	}
+	info := a.Session().ClientInfo
+	if info["version"] != "1.0.0" || info["type"] != "js" || info["name"] != "test" {
+		t.Errorf("wrong client info: %v", info)
+	}
+
+	// rejected with code
+	a, code, _ := rejectedHandshake(t, `{"sys":{"version":"0.9.0"}}`)
+	if code != handshakeOldClient || a.status != statusClosed {
+		t.Errorf("old client should be rejected with %d, got %d", handshakeOldClient, code)
+	}
+	a, code, msg := rejectedHandshake(t, `{"sys":{"version":"1.0.0"},"user":{"name":"banned"}}`)
+	if code != handshakeFail || msg != errBanned.Error() || a.status != statusClosed {
+		t.Errorf("banned client should be rejected with %d, got %d %s", handshakeFail, code, msg)
+	}
+
+	// data before handshake is rejected without validating
+	a = handshakeAgent()
+	handler.processPacket(a, &packet.Packet{Type: packet.Data, Data: []byte("data")})
+	if a.status != statusClosed || calls != 3 {
+		t.Error("data before handshake should close the agent")
+	}
+}
+
+func TestHandshakeResume(t *testing.T) {
+	defer func(ts *transportService, d time.Duration) {
+		transporter, env.resumeGrace, env.handshakeValidator = ts, d, nil
+	}(transporter, env.resumeGrace)
+	transporter = newTransporter()
+	env.resumeGrace = time.Minute
+
+	old := handshakeAgent()
+	old.resumeToken = "token"
+	transporter.agents[old.id] = old
+	transporter.suspend(old)
+	defer transporter.closeSuspended()
+
+	// rejected handshake does not destroy the suspended session
+	env.handshakeValidator = func(*session.Session, map[string]interface{}) error {
+		return errors.New("rejected")
+	}
+	a := handshakeAgent()
+	handshake(a, `{"sys":{"resume":"token"}}`)
+	if a.status != statusClosed || a.Session() == old.session {
+		t.Fatal("handshake should be rejected before resuming")
+	}
+	if !transporter.isSuspended(old) {
+		t.Fatal("suspended session destroyed by rejected handshake")
+	}
+
+	env.handshakeValidator = nil
+	a = handshakeAgent()
+	handshake(a, `{"sys":{"resume":"token","version":"1.0"}}`)
+	if a.status != statusHandshake || a.Session() != old.session {
+		t.Fatal("session should be resumed")
+	}
+	if a.Session().ClientInfo["version"] != "1.0" || a.Session().NetworkEntity() != a {
+		t.Error("resumed session not attached to the new agent")
+	}
+}
+
 func BenchmarkHandlerCallJSON(b *testing.B) {
 	SetSerializer(json.NewSerializer())
 	handler.register(&TestComp{})
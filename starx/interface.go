@@ -21,13 +21,17 @@
 package starx
 
 import (
+	"crypto/ecdsa"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/chrislonng/starx/cluster"
 	"github.com/chrislonng/starx/component"
+	"github.com/chrislonng/starx/message"
 	"github.com/chrislonng/starx/session"
+	"github.com/chrislonng/starx/transport"
 )
 
 // Run server
@@ -50,7 +54,6 @@ func Run() {
 	startup()
 }
 
-
 // Set special server initial function, starx.Set("oneServerType | anotherServerType", func(){})
 func Set(svrTypes string, fn func()) {
 	var types = strings.Split(strings.TrimSpace(svrTypes), "|")
@@ -92,7 +95,46 @@ func SetHeartbeatInternal(d time.Duration) {
 
 // SetCheckOriginFunc set the function that check `Origin` in http headers
 func SetCheckOriginFunc(fn func(*http.Request) bool) {
-	env.checkOrigin = fn
+	transport.WS.CheckOrigin = fn
+}
+
+// SetHandshakeValidator set the function that validate the handshake body of
+// client, the connection will be rejected when the function returns an error,
+// return ErrOldClient to notify client that the version is not fulfilled,
+// client information can be stored in session.ClientInfo, the validator is
+// called before session resumed, and the ClientInfo is carried to the resumed
+// session
+func SetHandshakeValidator(fn func(*session.Session, map[string]interface{}) error) {
+	env.handshakeValidator = fn
+}
+
+// SetSigningKey set the long-term key which signs the keys exchanged in
+// handshake when encryption enabled, the base64 encoded ASN.1 ECDSA signature
+// of SHA-256(server key || client key) is sent as `ecdhSig` in handshake
+// response, clients should verify it with the pinned public key, otherwise
+// encryption only protects against passive eavesdroppers
+func SetSigningKey(key *ecdsa.PrivateKey) {
+	env.signingKey = key
+}
+
+// SetCompressThreshold set the min data length of message which will be
+// deflated, compression is negotiated in handshake, data will be deflated
+// only when client supports it
+func SetCompressThreshold(n int) {
+	message.SetCompressThreshold(n)
+}
+
+// SetRouteCompression override the data compression of special route, e.g:
+// disable compression for the route whose data has been compressed already
+func SetRouteCompression(route string, enable bool) {
+	env.routeCompression[strings.TrimSpace(route)] = enable
+}
+
+// SetBackendRoutes set the routes handled by backend servers, e.g:
+// `chat.Room.Join`, frontend server appends them to the route dictionary, so
+// clients can use compressed routes for backend handlers as well
+func SetBackendRoutes(routes ...string) {
+	env.backendRoutes = routes
 }
 
 // EnableCluster enable cluster mode
@@ -111,6 +153,117 @@ func SetMasterServerID(id string) {
 	env.masterServerId = id
 }
 
+// Shutdown stops the server gracefully, refs shutdown, it returns after all
+// in-flight calls finished and all components shutdown. It could be called in
+// handlers, the call of caller itself and its client connection will not be
+// waited by draining
 func Shutdown() {
-	close(env.die)
+	switch callerFunc() {
+	case handlerCallFunc:
+		// frontend handler is called in the logic goroutine of its agent,
+		// which could not be closed before the handler returns
+		atomic.AddInt64(&waitingAgents, 1)
+		defer atomic.AddInt64(&waitingAgents, -1)
+		fallthrough
+	case remoteCallFunc:
+		atomic.AddInt64(&waitingCalls, 1)
+		defer atomic.AddInt64(&waitingCalls, -1)
+	}
+	shutdown()
+}
+
+// SetOverflowPolicy set the behavior when the send queue of a client is full,
+// timeout is only used by OverflowBlock, and the sender will be blocked
+// forever when it is not positive
+func SetOverflowPolicy(policy OverflowPolicy, timeout time.Duration) {
+	env.overflowPolicy = policy
+	env.sendTimeout = timeout
+}
+
+// SetResumeGracePeriod enables session resumption, session of the lost
+// connection will be kept for the grace period, and a reconnecting client could
+// reattach to it with the resume token issued in handshake, disabled when it is
+// not positive
+func SetResumeGracePeriod(d time.Duration) {
+	env.resumeGrace = d
+}
+
+// SetOfflineStore set the store for reliable pushes of users which are not
+// online, reliable pushes to offline users will fail when it is nil
+func SetOfflineStore(store OfflineStore) {
+	env.offlineStore = store
+}
+
+// SetMaxPendingPushes set the max count of unacked reliable pushes of a
+// session, the session will be kicked when client does not ack in time and the
+// count exceeds the limit
+func SetMaxPendingPushes(n int) {
+	env.maxPendingPushes = n
+}
+
+// PushReliable push message to user reliably, refs Session.PushReliable, the
+// message will be saved to offline store when user not online, only works in
+// frontend server
+func PushReliable(uid int64, route string, v interface{}) error {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		return err
+	}
+	return transporter.pushUser(uid, route, data)
+}
+
+// SetWorkerCount set the count of workers which handle requests in backend
+// server, requests of the same session are handled in order by one worker,
+// and requests of different sessions are handled in parallel, it should be
+// called before server started
+func SetWorkerCount(n int) {
+	env.workerCount = n
+}
+
+// UseBefore appends filters which will be called before handlers executed,
+// in both frontend and backend server, filters are called in appended order
+func UseBefore(filters ...BeforeFilter) {
+	env.beforeFilters = append(env.beforeFilters, filters...)
+}
+
+// UseAfter appends filters which will be called after handlers executed, in
+// both frontend and backend server, filters are called in appended order
+func UseAfter(filters ...AfterFilter) {
+	env.afterFilters = append(env.afterFilters, filters...)
+}
+
+// SetFlushInterval set the max duration which outbound packets could be
+// delayed to coalesce more packets in a single write, packets are written as
+// soon as the send queue drained when it is not positive
+func SetFlushInterval(d time.Duration) {
+	env.flushInterval = d
+}
+
+// SetMaxCoalescePackets set the max count of outbound packets coalesced in a
+// single write, packets are flushed once the count reached even the flush
+// interval not expired, packets are written one by one when it is not positive
+func SetMaxCoalescePackets(n int) {
+	env.maxCoalescePackets = n
+}
+
+// QueueDepth returns the count of packets waiting to be written to the
+// client, it is always zero for sessions in backend server
+func QueueDepth(s *session.Session) int {
+	if a, ok := s.NetworkEntity().(*agent); ok {
+		return a.queueDepth()
+	}
+	return 0
+}
+
+// SetShutdownMessage set the kick message which will be sent to clients when
+// server shutdown, v will be serialized by serializer unless it is []byte
+func SetShutdownMessage(v interface{}) {
+	env.shutdownMessage = v
+}
+
+// SetShutdownTimeout set the max duration waiting for in-flight handler and
+// rpc calls when server shutdown, and the max duration draining clients after
+// graceful restart
+func SetShutdownTimeout(d time.Duration) {
+	env.shutdownTimeout = d
 }
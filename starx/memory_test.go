@@ -0,0 +1,184 @@
+package starx
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/component"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/session"
+	"github.com/chrislonng/starx/transport"
+)
+
+type MemoryComp struct {
+	component.Base
+}
+
+func (c *MemoryComp) HandleEcho(s *session.Session, m *JsonMessage) (*JsonMessage, error) {
+	return &JsonMessage{Code: m.Code + 1, Data: m.Data}, nil
+}
+
+func (c *MemoryComp) HandleKick(s *session.Session, m *JsonMessage) error {
+	return s.Kick([]byte(m.Data))
+}
+
+var memoryServers int32
+
+// listenMemory listens on a unique memory address, and serves the connections
+// with handle, the returned func closes the listener
+func listenMemory(t *testing.T, name string, handle func(net.Conn)) (addr string, stop func()) {
+	addr = fmt.Sprintf("memory-%s-%d:1", name, atomic.AddInt32(&memoryServers, 1))
+	l, err := transport.Memory.Listen(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(l, handle)
+	return addr, func() { l.Close() }
+}
+
+// serveMemoryCluster serves a gate and its backend on memory transport in the
+// test process, both servers share the process globals, the gate is served by
+// handler and the backend by remote service, the returned func stops both.
+// Backend id is unique in every call, the rpc client of the stopped backend
+// removes the server by id asynchronously
+func serveMemoryCluster(t *testing.T) (gate string, stop func()) {
+	SetSerializer(json.NewSerializer())
+	remote.register(&MemoryComp{})
+	cluster.SetAppConfig(app.config)
+
+	addr, stopBackend := listenMemory(t, "backend", remote.handle)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &cluster.ServerConfig{
+		Type: "memory",
+		Id:   host,
+		Listeners: []*cluster.ListenerConfig{
+			{Protocol: cluster.ProtocolMemory, Host: host, Port: 1},
+		},
+	}
+	cluster.Register(backend)
+
+	gate, stopGate := listenMemory(t, "gate", handler.handle)
+	return gate, func() {
+		stopGate()
+		cluster.RemoveServer(backend.Id)
+		stopBackend()
+	}
+}
+
+// testClient is a client connected to the frontend, it talks with the default
+// codec over any transport
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	dec  *packet.Decoder
+}
+
+func dialMemory(t *testing.T, addr string) *testClient {
+	conn, err := transport.Memory.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, conn: conn, dec: packet.NewDecoder(conn, 0)}
+}
+
+func (c *testClient) write(typ packet.PacketType, data []byte) {
+	p, err := codec.Default.Pack(&packet.Packet{Type: typ, Data: data})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.conn.Write(p); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() *packet.Packet {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	p, err := c.dec.Decode(codec.Default)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p
+}
+
+func (c *testClient) handshake() {
+	c.write(packet.Handshake, []byte(`{}`))
+	if p := c.read(); p.Type != packet.Handshake {
+		c.t.Fatalf("expect handshake response, got %v", p)
+	}
+	c.write(packet.HandshakeAck, nil)
+}
+
+func (c *testClient) send(m *message.Message, v interface{}) {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	m.Data = data
+	em, err := codec.Default.Encode(m)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.write(packet.Data, em)
+}
+
+// TestMemoryRoundTrip sends a request to the gate, which is forwarded to the
+// backend and responded through the gate, all on memory transport
+func TestMemoryRoundTrip(t *testing.T) {
+	gate, stop := serveMemoryCluster(t)
+	defer stop()
+
+	c := dialMemory(t, gate)
+	defer c.conn.Close()
+	c.handshake()
+	c.send(&message.Message{Type: message.Request, ID: 1, Route: "memory.MemoryComp.HandleEcho"},
+		JsonMessage{Code: 1, Data: "hello world"})
+
+	p := c.read()
+	if p.Type != packet.Data {
+		t.Fatalf("expect data packet, got %v", p)
+	}
+	m, err := codec.Default.Decode(p.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := &JsonMessage{}
+	if err := serializer.Deserialize(m.Data, reply); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != message.Response || m.ID != 1 || reply.Code != 2 || reply.Data != "hello world" {
+		t.Errorf("wrong response: %s, %+v", m, reply)
+	}
+}
+
+// TestBackendKick kicks the client from backend handler, the kick should be
+// forwarded to the client by gate, and the connection closed after the kick
+// packet flushed
+func TestBackendKick(t *testing.T) {
+	gate, stop := serveMemoryCluster(t)
+	defer stop()
+
+	c := dialMemory(t, gate)
+	defer c.conn.Close()
+	c.handshake()
+	c.send(&message.Message{Type: message.Notify, Route: "memory.MemoryComp.HandleKick"},
+		JsonMessage{Data: "kicked by backend"})
+
+	if p := c.read(); p.Type != packet.Kick || string(p.Data) != "kicked by backend" {
+		t.Fatalf("expect kick packet, got %v", p)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.dec.Decode(codec.Default); err != io.EOF {
+		t.Fatalf("connection should be closed after kick, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package message
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// messages whose data length is less than threshold will not be deflated
+var compressThreshold = 1024
+
+// InflateRatio is the multiple of the max inbound message length that the
+// inflated data is allowed to reach, which bounds the amplification of a
+// deflated message
+const InflateRatio = 4
+
+// DefaultMaxInflateSize is the max data length after inflated when the max
+// inbound message length is not configured
+const DefaultMaxInflateSize = 1 << 20
+
+// max data length after inflated, deflated data will be rejected when it is
+// inflated to larger than the limit
+var maxInflateSize = DefaultMaxInflateSize
+
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+// SetCompressThreshold set the min data length of message which will be
+// deflated, it should be set before server startup
+func SetCompressThreshold(n int) {
+	compressThreshold = n
+}
+
+// SetMaxInflateSize set the max data length after inflated, it should be set
+// before server startup, DefaultMaxInflateSize is used by default, and server
+// sets it to InflateRatio times of max_packet_size when the latter configured,
+// a larger limit allows small packets to be inflated to large buffers
+func SetMaxInflateSize(n int) {
+	maxInflateSize = n
+}
+
+func deflate(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/2))
+	w := writerPool.Get().(*flate.Writer)
+	defer writerPool.Put(w)
+
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	// read one more byte to detect the data exceeds limit
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(maxInflateSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxInflateSize {
+		return nil, ErrInflateTooLarge
+	}
+	return data, nil
+}
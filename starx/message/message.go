@@ -1,46 +1,63 @@
 package message
 
 import (
+	"crypto/md5"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/chrislonng/starx/log"
-	"strings"
 )
 
 type MessageType byte
 
 const (
-	Request  MessageType = 0x00
-	Notify               = 0x01
-	Response             = 0x02
-	Push                 = 0x03
+	Request      MessageType = 0x00
+	Notify                   = 0x01
+	Response                 = 0x02
+	Push                     = 0x03
+	ReliablePush             = 0x04 // push retained until client acks, message id is sequence number
+	Ack                      = 0x05 // client acks all reliable pushes up to message id
 )
 
 const (
 	msgRouteCompressMask = 0x01
+	msgDataCompressMask  = 0x10
 	msgTypeMask          = 0x07
 	msgRouteLengthMask   = 0xFF
 	msgHeadLength        = 0x03
+	maxVarintLength      = 10 // max bytes of variant length encoded 64 bits id
 )
 
 var types = map[MessageType]string{
-	Request:  "Request",
-	Notify:   "Notify",
-	Response: "Response",
-	Push:     "Push",
+	Request:      "Request",
+	Notify:       "Notify",
+	Response:     "Response",
+	Push:         "Push",
+	ReliablePush: "ReliablePush",
+	Ack:          "Ack",
 }
 
 var (
-	routeDict = make(map[string]uint16)
-	codeDict  = make(map[uint16]string)
+	dictLock    sync.RWMutex // protects all dictionary fields below
+	routeDict   = make(map[string]uint16)
+	codeDict    = make(map[uint16]string)
+	dictVersion = version()
 )
 
 var (
-	ErrWrongMessageType  = errors.New("wrong message type")
-	ErrInvalidMessage    = errors.New("invalid message")
-	ErrRouteInfoNotFound = errors.New("route info not found in dictionary")
+	ErrWrongMessageType   = errors.New("wrong message type")
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrRouteInfoNotFound  = errors.New("route info not found in dictionary")
+	ErrTruncatedID        = errors.New("message id truncated")
+	ErrIDOverflow         = errors.New("message id overflows 64 bits")
+	ErrTruncatedRoute     = errors.New("message route truncated")
+	ErrInvalidDeflateData = errors.New("invalid deflate data")
+	ErrInflateTooLarge    = errors.New("inflated data too large")
 )
 
 type Message struct {
@@ -48,6 +65,7 @@ type Message struct {
 	ID         uint
 	Route      string
 	Data       []byte
+	Deflate    bool // deflate data when encoding, or data has been deflated when decoded
 	compressed bool
 }
 
@@ -56,11 +74,12 @@ func New() *Message {
 }
 
 func (m *Message) String() string {
-	return fmt.Sprintf("Type: %s, ID: %d, Route: %s, Compressed: %t, BodyLength: %d",
+	return fmt.Sprintf("Type: %s, ID: %d, Route: %s, Compressed: %t, Deflate: %t, BodyLength: %d",
 		types[m.Type],
 		m.ID,
 		m.Route,
 		m.compressed,
+		m.Deflate,
 		len(m.Data))
 }
 
@@ -69,25 +88,66 @@ func (m *Message) Encode() ([]byte, error) {
 }
 
 func msgRoute(t MessageType) bool {
-	return t == Request || t == Notify || t == Push
+	return t == Request || t == Notify || t == Push || t == ReliablePush
+}
+
+func msgID(t MessageType) bool {
+	return t == Request || t == Response || t == ReliablePush || t == Ack
 }
 
 func invalidType(t MessageType) bool {
-	return t < Request || t > Push
+	return t < Request || t > Ack
 }
 
 // Encode message. Different message types is corresponding to different message header,
 // message types is identified by 2-4 bit of flag field. The relationship between message
 // types and message header is presented as follows:
 //
-//   type      flag      other
-//   ----      ----      -----
+//	type      flag      other
+//	----      ----      -----
+//
 // request  |----000-|<message id>|<route>
 // notify   |----001-|<route>
 // response |----010-|<message id>
 // push     |----011-|<route>
+// reliable |----100-|<message id>|<route>
+// ack      |----101-|<message id>
 // The figure above indicates that the bit does not affect the type of message.
+//
+// The 5th bit of flag field indicates the data has been deflated, data will be
+// deflated only when message.Deflate is set and the data length is not less
+// than compress threshold
+//
+//	flag
+//	----
+//	---1----
 func Encode(m *Message) ([]byte, error) {
+	return encode(m, varintID)
+}
+
+// EncodeV2 encode message with v2 layout, which is same as pomelo layout
+// except the message id is encoded as 8 bytes integer(big end)
+//
+//	type      flag      other
+//	----      ----      -----
+//
+// request  |----000-|<8 bytes message id>|<route>
+// response |----010-|<8 bytes message id>
+// reliable |----100-|<8 bytes message id>|<route>
+// ack      |----101-|<8 bytes message id>
+func EncodeV2(m *Message) ([]byte, error) {
+	return encode(m, fixedID)
+}
+
+// idEncoding represents how message id is encoded in message header
+type idEncoding byte
+
+const (
+	varintID idEncoding = iota // variant length encode
+	fixedID                    // 8 bytes big end
+)
+
+func encode(m *Message, ide idEncoding) ([]byte, error) {
 	if invalidType(m.Type) {
 		log.Errorf("wrong message type")
 		return nil, ErrWrongMessageType
@@ -96,13 +156,33 @@ func Encode(m *Message) ([]byte, error) {
 	buf := make([]byte, 0)
 	flag := byte(m.Type) << 1
 
+	data := m.Data
+	if m.Deflate && len(data) >= compressThreshold {
+		d, err := deflate(data)
+		if err != nil {
+			log.Errorf(err.Error())
+			return nil, err
+		}
+		// keep raw data when deflated data is not smaller
+		if len(d) < len(data) {
+			flag |= msgDataCompressMask
+			data = d
+		}
+	}
+
+	dictLock.RLock()
 	code, compressed := routeDict[m.Route]
+	dictLock.RUnlock()
 	if compressed {
 		flag |= msgRouteCompressMask
 	}
 	buf = append(buf, flag)
 
-	if m.Type == Request || m.Type == Response {
+	if msgID(m.Type) && ide == fixedID {
+		var id [8]byte
+		binary.BigEndian.PutUint64(id[:], uint64(m.ID))
+		buf = append(buf, id[:]...)
+	} else if msgID(m.Type) {
 		n := m.ID
 		// variant length encode
 		for {
@@ -127,13 +207,30 @@ func Encode(m *Message) ([]byte, error) {
 		}
 	}
 
-	buf = append(buf, m.Data...)
+	buf = append(buf, data...)
 	return buf, nil
 }
 
+// TypeOf returns the type of encoded message without decoding it, messages
+// encoded by Encode and EncodeV2 share the same flag layout
+func TypeOf(data []byte) (MessageType, error) {
+	if len(data) < 1 {
+		return 0, ErrInvalidMessage
+	}
+	return MessageType((data[0] >> 1) & msgTypeMask), nil
+}
+
 func Decode(data []byte) (*Message, error) {
-	if len(data) <= msgHeadLength {
-		log.Infof("invalid message")
+	return decode(data, varintID)
+}
+
+// DecodeV2 decode message with v2 layout, refs EncodeV2
+func DecodeV2(data []byte) (*Message, error) {
+	return decode(data, fixedID)
+}
+
+func decode(data []byte, ide idEncoding) (*Message, error) {
+	if len(data) < 1 {
 		return nil, ErrInvalidMessage
 	}
 	m := New()
@@ -146,27 +243,31 @@ func Decode(data []byte) (*Message, error) {
 		return nil, ErrWrongMessageType
 	}
 
-	if m.Type == Request || m.Type == Response {
-		id := uint(0)
-		// little end byte order
-		// WARNING: must can be stored in 64 bits integer
-		// variant length encode
-		for i := offset; i < len(data); i++ {
-			b := data[i]
-			id += (uint(b&0x7F) << uint(7*(i-offset)))
-			if b < 128 {
-				offset = i + 1
-				break
-			}
+	if msgID(m.Type) && ide == fixedID {
+		if len(data) < offset+8 {
+			return nil, ErrTruncatedID
+		}
+		m.ID = uint(binary.BigEndian.Uint64(data[offset:(offset + 8)]))
+		offset += 8
+	} else if msgID(m.Type) {
+		id, n, err := readVarint(data[offset:])
+		if err != nil {
+			return nil, err
 		}
 		m.ID = id
+		offset += n
 	}
 
 	if msgRoute(m.Type) {
 		if flag&msgRouteCompressMask == 1 {
+			if len(data) < offset+2 {
+				return nil, ErrTruncatedRoute
+			}
 			m.compressed = true
 			code := binary.BigEndian.Uint16(data[offset:(offset + 2)])
+			dictLock.RLock()
 			route, ok := codeDict[code]
+			dictLock.RUnlock()
 			if !ok {
 				log.Errorf("message compressed, but can not find route infomation in dictionary")
 				return nil, ErrRouteInfoNotFound
@@ -174,21 +275,66 @@ func Decode(data []byte) (*Message, error) {
 			m.Route = route
 			offset += 2
 		} else {
+			if len(data) < offset+1 {
+				return nil, ErrTruncatedRoute
+			}
 			m.compressed = false
-			rl := data[offset]
+			rl := int(data[offset])
 			offset += 1
-			m.Route = string(data[offset:(offset + int(rl))])
-			offset += int(rl)
+			if len(data) < offset+rl {
+				return nil, ErrTruncatedRoute
+			}
+			m.Route = string(data[offset:(offset + rl)])
+			offset += rl
 		}
 	}
 
 	m.Data = data[offset:]
+	if flag&msgDataCompressMask != 0 {
+		d, err := inflate(m.Data)
+		if err == ErrInflateTooLarge {
+			return nil, err
+		}
+		if err != nil {
+			return nil, ErrInvalidDeflateData
+		}
+		m.Deflate = true
+		m.Data = d
+	}
 	return m, nil
 }
 
+// readVarint reads the variant length encoded message id(little end), returns
+// the id and the number of bytes read, the id must be stored in 64 bits
+func readVarint(data []byte) (uint, int, error) {
+	var id uint64
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		// the 10th byte can only hold 1 bit
+		if i == maxVarintLength-1 && b > 1 {
+			return 0, 0, ErrIDOverflow
+		}
+		id |= uint64(b&0x7F) << uint(7*i)
+		if b < 128 {
+			return uint(id), i + 1, nil
+		}
+		if i == maxVarintLength-1 {
+			return 0, 0, ErrIDOverflow
+		}
+	}
+	return 0, 0, ErrTruncatedID
+}
+
 // TODO: ***NOTICE***
 // Runtime set dictionary will be a dangerous operation!!!!!!
 func SetDict(dict map[string]uint16) {
+	dictLock.Lock()
+	defer dictLock.Unlock()
+
+	setDict(dict)
+}
+
+func setDict(dict map[string]uint16) {
 	for route, code := range dict {
 		r := strings.TrimSpace(route)
 
@@ -205,4 +351,84 @@ func SetDict(dict map[string]uint16) {
 		routeDict[r] = code
 		codeDict[code] = r
 	}
+	dictVersion = version()
+}
+
+// AppendDict appends routes which have not been contained in dictionary,
+// new codes are allocated after the max code in current dictionary, routes
+// are sorted before allocating, so the same routes always get the same codes
+func AppendDict(routes []string) {
+	dictLock.Lock()
+	defer dictLock.Unlock()
+
+	var maxCode uint16
+	for code := range codeDict {
+		if code > maxCode {
+			maxCode = code
+		}
+	}
+
+	sorted := make([]string, 0, len(routes))
+	for _, route := range routes {
+		r := strings.TrimSpace(route)
+		if r == "" {
+			continue
+		}
+		if _, ok := routeDict[r]; ok {
+			continue
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Strings(sorted)
+
+	dict := make(map[string]uint16)
+	for _, r := range sorted {
+		if _, ok := dict[r]; ok {
+			continue
+		}
+		if maxCode == 0xFFFF {
+			log.Warnf("route dictionary overflow, route: %s", r)
+			break
+		}
+		maxCode++
+		dict[r] = maxCode
+	}
+	setDict(dict)
+}
+
+// Dict returns a copy of route dictionary
+func Dict() map[string]uint16 {
+	dictLock.RLock()
+	defer dictLock.RUnlock()
+
+	dict := make(map[string]uint16, len(routeDict))
+	for route, code := range routeDict {
+		dict[route] = code
+	}
+	return dict
+}
+
+// DictVersion returns the hash of current route dictionary, client can cache
+// the dictionary and compare the version in later handshake
+func DictVersion() string {
+	dictLock.RLock()
+	defer dictLock.RUnlock()
+
+	return dictVersion
+}
+
+// version computes the hash of route dictionary, it should be called with
+// dictionary lock held
+func version() string {
+	routes := make([]string, 0, len(routeDict))
+	for route := range routeDict {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	h := md5.New()
+	for _, route := range routes {
+		fmt.Fprintf(h, "%s:%d;", route, routeDict[route])
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
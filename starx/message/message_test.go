@@ -2,7 +2,10 @@ package message
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/chrislonng/starx/log"
 )
 
 func TestEncode(t *testing.T) {
@@ -162,3 +165,250 @@ func TestEncode(t *testing.T) {
 		t.Error("not equal")
 	}
 }
+
+// emptyDict replaces the route dictionary with an empty one, the returned
+// func restores the replaced dictionary
+func emptyDict() func() {
+	dictLock.Lock()
+	defer dictLock.Unlock()
+
+	routes, codes, v := routeDict, codeDict, dictVersion
+	routeDict, codeDict = make(map[string]uint16), make(map[uint16]string)
+	dictVersion = version()
+	return func() {
+		dictLock.Lock()
+		defer dictLock.Unlock()
+
+		routeDict, codeDict, dictVersion = routes, codes, v
+	}
+}
+
+func TestAppendDict(t *testing.T) {
+	defer emptyDict()()
+
+	SetDict(map[string]uint16{"test.append.exists": 1000})
+	v1 := DictVersion()
+
+	AppendDict([]string{"test.append.b", "test.append.a", "test.append.exists", " "})
+
+	dict := Dict()
+	if dict["test.append.exists"] != 1000 {
+		t.Error("existing route code should not change")
+	}
+	if dict["test.append.a"] != 1001 || dict["test.append.b"] != 1002 {
+		t.Error("wrong code allocated")
+	}
+	if _, ok := dict[""]; ok {
+		t.Error("empty route should be ignored")
+	}
+
+	v2 := DictVersion()
+	if v1 == v2 {
+		t.Error("dictionary version should change")
+	}
+
+	AppendDict([]string{"test.append.a"})
+	if v2 != DictVersion() {
+		t.Error("dictionary version should not change")
+	}
+}
+
+func TestEncodeV2(t *testing.T) {
+	m1 := &Message{
+		Type:  Request,
+		ID:    1<<63 + 1,
+		Route: "test.test.test4",
+		Data:  []byte(`hello world`),
+	}
+	em1, err := EncodeV2(m1)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	dm1, err := DecodeV2(em1)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !reflect.DeepEqual(m1, dm1) {
+		t.Error("not equal")
+	}
+
+	m2 := &Message{
+		Type: Response,
+		ID:   100,
+		Data: []byte(`hello world`),
+	}
+	em2, err := EncodeV2(m2)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	dm2, err := DecodeV2(em2)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !reflect.DeepEqual(m2, dm2) {
+		t.Error("not equal")
+	}
+
+	if _, err := DecodeV2(em2[:5]); err == nil {
+		t.Error("should err")
+	}
+}
+
+func TestEncodeDeflate(t *testing.T) {
+	SetCompressThreshold(64)
+	defer SetCompressThreshold(1024)
+
+	data := []byte(strings.Repeat("hello world", 100))
+	m1 := &Message{
+		Type:    Push,
+		Route:   "test.test.test4",
+		Data:    data,
+		Deflate: true,
+	}
+	em1, err := m1.Encode()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if em1[0]&msgDataCompressMask == 0 || len(em1) >= len(data) {
+		t.Error("data should be deflated")
+	}
+	dm1, err := Decode(em1)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !reflect.DeepEqual(m1, dm1) {
+		t.Error("not equal")
+	}
+
+	// below threshold
+	m2 := &Message{
+		Type:    Response,
+		ID:      100,
+		Data:    []byte(`hello world`),
+		Deflate: true,
+	}
+	em2, err := m2.Encode()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if em2[0]&msgDataCompressMask != 0 {
+		t.Error("data should not be deflated")
+	}
+	dm2, err := Decode(em2)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if dm2.Deflate || !reflect.DeepEqual(m2.Data, dm2.Data) {
+		t.Error("not equal")
+	}
+
+	// deflated data inflates beyond limit
+	SetMaxInflateSize(len(data) - 1)
+	defer SetMaxInflateSize(DefaultMaxInflateSize)
+	if _, err := Decode(em1); err != ErrInflateTooLarge {
+		t.Errorf("expect %v, got %v", ErrInflateTooLarge, err)
+	}
+	SetMaxInflateSize(len(data))
+	if _, err := Decode(em1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	cases := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{}, ErrInvalidMessage},
+		{[]byte{0x00}, ErrTruncatedID},
+		{[]byte{0x00, 0x80}, ErrTruncatedID},
+		{[]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02}, ErrIDOverflow},
+		{[]byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x81, 0x00}, ErrIDOverflow},
+		{[]byte{0x02}, ErrTruncatedRoute},
+		{[]byte{0x03, 0x00}, ErrTruncatedRoute},
+		{[]byte{0x02, 0x05, 'a'}, ErrTruncatedRoute},
+		{[]byte{0x06, 0x01, 'a', 0xff}, nil},
+		{[]byte{0x16, 0x01, 'a', 0xff, 0xff}, ErrInvalidDeflateData},
+		{[]byte{0x04, 0x01}, nil},
+	}
+
+	for i, c := range cases {
+		if _, err := Decode(c.data); err != c.err {
+			t.Errorf("case %d: expect %v, got %v", i, c.err, err)
+		}
+	}
+
+	if _, err := DecodeV2([]byte{0x00, 0x01, 0x02}); err != ErrTruncatedID {
+		t.Errorf("expect %v, got %v", ErrTruncatedID, err)
+	}
+
+	// max uint64 message id
+	m := &Message{Type: Response, ID: ^uint(0), Data: []byte{}}
+	em, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm, err := Decode(em)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dm.ID != m.ID {
+		t.Error("wrong message id")
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	log.SetLevel(log.LevelClose)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, decode := range []func([]byte) (*Message, error){Decode, DecodeV2} {
+			m, err := decode(data)
+			if err != nil {
+				continue
+			}
+			if invalidType(m.Type) {
+				t.Errorf("invalid message type: %d", m.Type)
+			}
+		}
+	})
+}
+
+func TestEncodeReliablePush(t *testing.T) {
+	m1 := &Message{
+		Type:  ReliablePush,
+		ID:    300,
+		Route: "test.test.reliable",
+		Data:  []byte(`hello world`),
+	}
+	m2 := &Message{
+		Type: Ack,
+		ID:   300,
+		Data: []byte{},
+	}
+
+	for _, m := range []*Message{m1, m2} {
+		em, err := Encode(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm, err := Decode(em)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, dm) {
+			t.Errorf("not equal, %s", dm)
+		}
+
+		em, err = EncodeV2(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm, err = DecodeV2(em)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, dm) {
+			t.Errorf("not equal, %s", dm)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultMaxPacketSize is the max packet data length which pomelo
+	// protocol allowed(3 bytes length)
+	DefaultMaxPacketSize = 1<<24 - 1
+
+	readBufferSize = 4096
+)
+
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// Framing represents the layout of packet header
+type Framing interface {
+	HeadLength() int
+	ParseHead(head []byte) (PacketType, int, error)
+}
+
+var (
+	V1 Framing = v1Framing{} // pomelo framing, 3 bytes length
+	V2 Framing = v2Framing{} // v2 framing, 4 bytes length
+)
+
+type v1Framing struct{}
+
+func (v1Framing) HeadLength() int {
+	return HeadLength
+}
+
+func (v1Framing) ParseHead(head []byte) (PacketType, int, error) {
+	return ParseHead(head)
+}
+
+type v2Framing struct{}
+
+func (v2Framing) HeadLength() int {
+	return HeadLengthV2
+}
+
+func (v2Framing) ParseHead(head []byte) (PacketType, int, error) {
+	return ParseHeadV2(head)
+}
+
+// ParseHead parse pomelo packet header, refs Pack
+func ParseHead(head []byte) (PacketType, int, error) {
+	t := PacketType(head[0])
+	if t < Handshake || t > Kick {
+		return 0, 0, ErrWrongPacketType
+	}
+	return t, bytesToInt(head[1:HeadLength]), nil
+}
+
+// ParseHeadV2 parse v2 packet header, refs PackV2
+func ParseHeadV2(head []byte) (PacketType, int, error) {
+	t := PacketType(head[0])
+	if t < Handshake || t > Kick {
+		return 0, 0, ErrWrongPacketType
+	}
+	return t, int(binary.BigEndian.Uint32(head[1:HeadLengthV2])), nil
+}
+
+// Decoder reads and decodes packets from a stream, the read buffer is reused
+// across packets, and packet data is allocated from a pool after the length
+// has been checked against the max packet size.
+// Decoder is not safe for concurrent use.
+type Decoder struct {
+	r       io.Reader
+	buf     []byte // read buffer
+	start   int    // start of unread data in buf
+	end     int    // end of unread data in buf
+	maxSize int    // max packet data length
+}
+
+// NewDecoder returns a decoder reading from r, packets whose data length is
+// greater than maxSize will be rejected, DefaultMaxPacketSize will be used
+// when maxSize is not positive
+func NewDecoder(r io.Reader, maxSize int) *Decoder {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPacketSize
+	}
+	return &Decoder{
+		r:       r,
+		buf:     make([]byte, readBufferSize),
+		maxSize: maxSize,
+	}
+}
+
+// fill ensures at least n bytes unread in buffer, n must not be greater than
+// buffer size
+func (d *Decoder) fill(n int) error {
+	if d.end-d.start >= n {
+		return nil
+	}
+
+	// move unread data to the beginning of buffer
+	if d.start > 0 {
+		d.end = copy(d.buf, d.buf[d.start:d.end])
+		d.start = 0
+	}
+
+	for d.end < n {
+		m, err := d.r.Read(d.buf[d.end:])
+		d.end += m
+		if err != nil {
+			if err == io.EOF && d.end > 0 && d.end < n {
+				return io.ErrUnexpectedEOF
+			}
+			if d.end >= n {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode reads next packet with the framing, the framing could be changed
+// between packets. Data of returned packet should be released by Release
+// when it is no longer used.
+func (d *Decoder) Decode(f Framing) (*Packet, error) {
+	hl := f.HeadLength()
+	if err := d.fill(hl); err != nil {
+		return nil, err
+	}
+
+	t, length, err := f.ParseHead(d.buf[d.start:(d.start + hl)])
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > d.maxSize {
+		return nil, ErrPacketTooLarge
+	}
+	d.start += hl
+
+	data := alloc(length)
+	n := copy(data, d.buf[d.start:d.end])
+	d.start += n
+
+	// read remain data directly, avoid copying large packet through buffer
+	if n < length {
+		if _, err := io.ReadFull(d.r, data[n:]); err != nil {
+			Release(&Packet{Data: data})
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	return &Packet{Type: t, Length: length, Data: data}, nil
+}
+
+// Payload buffer pools, the capacity of buffers in pools[i] is 1<<(i+minPoolShift)
+const (
+	minPoolShift = 6  // 64 bytes
+	maxPoolShift = 16 // 64 KB
+)
+
+var pools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+func poolIndex(n int) int {
+	i := 0
+	for size := 1 << minPoolShift; size < n; size <<= 1 {
+		i++
+	}
+	return i
+}
+
+func alloc(n int) []byte {
+	if n > 1<<maxPoolShift {
+		return make([]byte, n)
+	}
+
+	i := poolIndex(n)
+	if buf, ok := pools[i].Get().(*[]byte); ok {
+		return (*buf)[:n]
+	}
+	return make([]byte, n, 1<<uint(i+minPoolShift))
+}
+
+// Release puts the packet data back to the pool, packet data must not be
+// used after released
+func Release(p *Packet) {
+	if p == nil || p.Data == nil {
+		return
+	}
+
+	c := cap(p.Data)
+	if c < 1<<minPoolShift || c > 1<<maxPoolShift || c&(c-1) != 0 {
+		return
+	}
+
+	buf := p.Data[:0]
+	p.Data = nil
+	pools[poolIndex(c)].Put(&buf)
+}
@@ -1,8 +1,10 @@
 package packet
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+
 	"github.com/chrislonng/starx/log"
 )
 
@@ -17,7 +19,10 @@ const (
 	Kick                    = 0x05 // disconnect message from server
 )
 
-const HeadLength = 4
+const (
+	HeadLength   = 4 // pomelo packet head length
+	HeadLengthV2 = 5 // v2 packet head length
+)
 
 var ErrWrongPacketType = errors.New("wrong packet type")
 
@@ -63,6 +68,10 @@ func (p *Packet) String() string {
 // Unpack binary data to packet, if packet has not been received completely,
 // return nil and incomplete data, concrete protocol ref pack function
 func Unpack(data []byte) (*Packet, []byte, error) {
+	if len(data) < HeadLength {
+		return nil, data, nil
+	}
+
 	t := PacketType(data[0])
 	if t < Handshake || t > Kick {
 		log.Errorf("wrong packet type")
@@ -81,6 +90,52 @@ func Unpack(data []byte) (*Packet, []byte, error) {
 	return p, data[(length + HeadLength):], nil
 }
 
+// PackV2 pack packet with v2 layout, which has a 4 bytes length field
+//
+// -<type>-|-----------<length>-----------|-<data>-
+// --------|------------------------------|--------
+// 1 byte packet type, 4 bytes packet data length(big end), and data segment
+func PackV2(p *Packet) ([]byte, error) {
+	if p.Type < Handshake || p.Type > Kick {
+		log.Errorf("wrong packet type")
+		return nil, ErrWrongPacketType
+	}
+
+	p.Length = len(p.Data)
+
+	buf := make([]byte, p.Length+HeadLengthV2)
+	buf[0] = byte(p.Type)
+
+	binary.BigEndian.PutUint32(buf[1:HeadLengthV2], uint32(p.Length))
+	copy(buf[HeadLengthV2:], p.Data)
+	return buf, nil
+}
+
+// UnpackV2 unpack binary data with v2 layout, if packet has not been received
+// completely, return nil and incomplete data
+func UnpackV2(data []byte) (*Packet, []byte, error) {
+	if len(data) < HeadLengthV2 {
+		return nil, data, nil
+	}
+
+	t := PacketType(data[0])
+	if t < Handshake || t > Kick {
+		log.Errorf("wrong packet type")
+		return nil, nil, ErrWrongPacketType
+	}
+
+	length := int(binary.BigEndian.Uint32(data[1:HeadLengthV2]))
+	if length > (len(data) - HeadLengthV2) {
+		return nil, data, nil
+	}
+	p := &Packet{
+		Type:   t,
+		Length: length,
+		Data:   data[HeadLengthV2:(length + HeadLengthV2)],
+	}
+	return p, data[(length + HeadLengthV2):], nil
+}
+
 // Decode packet data length byte to int(Big end)
 func bytesToInt(b []byte) int {
 	result := 0
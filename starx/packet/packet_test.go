@@ -1,8 +1,13 @@
 package packet
 
 import (
+	"bytes"
+	"io"
 	"reflect"
 	"testing"
+	"testing/iotest"
+
+	"github.com/chrislonng/starx/log"
 )
 
 func TestPack(t *testing.T) {
@@ -65,3 +70,122 @@ func TestPack(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestPackV2(t *testing.T) {
+	data := []byte("hello world")
+	p1 := &Packet{Type: PacketType(4), Data: data, Length: len(data)}
+	pp1, err := PackV2(p1)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(pp1) != len(data)+HeadLengthV2 {
+		t.Error("wrong packet length")
+	}
+
+	// incomplete packet
+	upp1, rest, err := UnpackV2(pp1[:len(pp1)-1])
+	if err != nil || upp1 != nil || len(rest) != len(pp1)-1 {
+		t.Error("should wait for more data")
+	}
+
+	upp1, rest, err = UnpackV2(pp1)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(rest) > 0 {
+		t.Error("rest should empty")
+	}
+	if !reflect.DeepEqual(p1, upp1) {
+		t.Fail()
+	}
+
+	if _, _, err := UnpackV2([]byte{0x06, 0x00, 0x00, 0x00, 0x00}); err == nil {
+		t.Error("should err")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var stream []byte
+	var packets []*Packet
+	for i := 0; i < 100; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, i*97)
+		p := &Packet{Type: Data, Data: data, Length: len(data)}
+		pp, err := p.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, pp...)
+		packets = append(packets, p)
+	}
+
+	// read one byte at a time to simulate truncated data
+	d := NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)), 0)
+	for i, p := range packets {
+		dp, err := d.Decode(V1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dp.Type != p.Type || !bytes.Equal(dp.Data, p.Data) {
+			t.Errorf("packet %d not equal", i)
+		}
+		Release(dp)
+	}
+
+	if _, err := d.Decode(V1); err != io.EOF {
+		t.Errorf("should be EOF, got %v", err)
+	}
+}
+
+func TestDecoderMaxSize(t *testing.T) {
+	p := &Packet{Type: Data, Data: make([]byte, 1025)}
+	pp, err := PackV2(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(pp), 1024)
+	if _, err := d.Decode(V2); err != ErrPacketTooLarge {
+		t.Errorf("should be ErrPacketTooLarge, got %v", err)
+	}
+
+	d = NewDecoder(bytes.NewReader([]byte{0x06, 0x00, 0x00, 0x01}), 0)
+	if _, err := d.Decode(V1); err != ErrWrongPacketType {
+		t.Errorf("should be ErrWrongPacketType, got %v", err)
+	}
+
+	d = NewDecoder(bytes.NewReader(pp[:10]), 0)
+	if _, err := d.Decode(V2); err != io.ErrUnexpectedEOF {
+		t.Errorf("should be ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func FuzzUnpack(f *testing.F) {
+	log.SetLevel(log.LevelClose)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, unpack := range []func([]byte) (*Packet, []byte, error){Unpack, UnpackV2} {
+			p, rest, err := unpack(data)
+			if err != nil || p == nil {
+				continue
+			}
+			if len(p.Data) != p.Length {
+				t.Errorf("wrong packet length, expect %d, got %d", p.Length, len(p.Data))
+			}
+			if len(rest) > len(data) {
+				t.Error("wrong rest")
+			}
+		}
+
+		d := NewDecoder(bytes.NewReader(data), 1024)
+		for {
+			p, err := d.Decode(V1)
+			if err != nil {
+				break
+			}
+			if len(p.Data) != p.Length || p.Length > 1024 {
+				t.Errorf("wrong packet length: %d", p.Length)
+			}
+			Release(p)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+// Default worker count of the backend server, handlers usually block on
+// database or other rpc calls, so it is not bound to cpu count
+const defaultWorkerCount = 64
+
+// workerPool executes tasks in a fixed number of workers, tasks with the same
+// key are executed in the same worker in submitted order, tasks with different
+// keys are executed in parallel
+type workerPool struct {
+	queues []chan func()
+}
+
+func newWorkerPool(workers, size int) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &workerPool{queues: make([]chan func(), workers)}
+	for i := range p.queues {
+		q := make(chan func(), size)
+		p.queues[i] = q
+		go func() {
+			for task := range q {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues the task to the worker of key, blocks when the queue is full
+func (p *workerPool) submit(key uint64, task func()) {
+	p.queues[key%uint64(len(p.queues))] <- task
+}
@@ -0,0 +1,49 @@
+package starx
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	p := newWorkerPool(4, 16)
+
+	// tasks of the same key are executed in order
+	const count = 1000
+	var wg sync.WaitGroup
+	results := make(map[uint64][]int)
+	var mu sync.Mutex
+	for i := 0; i < count; i++ {
+		key := uint64(i % 8)
+		n := i
+		wg.Add(1)
+		p.submit(key, func() {
+			defer wg.Done()
+			mu.Lock()
+			results[key] = append(results[key], n)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for key, ns := range results {
+		for i := 1; i < len(ns); i++ {
+			if ns[i] < ns[i-1] {
+				t.Fatalf("tasks of key %d out of order", key)
+			}
+		}
+	}
+
+	// slow task does not block tasks of other workers
+	block := make(chan struct{})
+	p.submit(0, func() { <-block })
+	done := make(chan struct{})
+	p.submit(1, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("task blocked by other worker")
+	}
+	close(block)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"sync"
+	"time"
+)
+
+// sendQueue is the outbound packet queue of an agent, packets are written in
+// the order they were queued. Data packets of push and response are limited
+// by the queue size, and could be dropped when the queue overflows, control
+// packets have the reserved capacity, e.g. kick and reliable push, which are
+// never dropped
+type sendQueue struct {
+	sync.Mutex
+	packets []queuedPacket // queued packets in order
+	data    int            // count of queued data packets
+	size    int            // max count of queued data packets
+	reserve int            // max count of queued control packets
+	closed  bool
+	ready   chan struct{} // signaled when packets queued
+	space   chan struct{} // closed when packets dequeued, nil when no sender waits
+}
+
+type queuedPacket struct {
+	data    []byte
+	control bool
+}
+
+func newSendQueue(size, reserve int) *sendQueue {
+	return &sendQueue{
+		size:    size,
+		reserve: reserve,
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// len returns the count of queued packets
+func (q *sendQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.packets)
+}
+
+func (q *sendQueue) full(control bool) bool {
+	if control {
+		return len(q.packets)-q.data >= q.reserve
+	}
+	return q.data >= q.size
+}
+
+// append queues the packet, and signals the writer, it should be called with
+// the lock held
+func (q *sendQueue) append(data []byte, control bool) {
+	q.packets = append(q.packets, queuedPacket{data: data, control: control})
+	if !control {
+		q.data++
+	}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// tryPush queues the packet without blocking, false will be returned when the
+// queue of the packet kind is full
+func (q *sendQueue) tryPush(data []byte, control bool) (bool, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return false, ErrSendChannelClosed
+	}
+	if q.full(control) {
+		return false, nil
+	}
+	q.append(data, control)
+	return true, nil
+}
+
+// push queues the packet, it blocks until the queue of the packet kind has
+// room, ErrSendTimeout will be returned when timeout is positive and expired
+func (q *sendQueue) push(data []byte, control bool, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		q.Lock()
+		if q.closed {
+			q.Unlock()
+			return ErrSendChannelClosed
+		}
+		if !q.full(control) {
+			q.append(data, control)
+			q.Unlock()
+			return nil
+		}
+		if q.space == nil {
+			q.space = make(chan struct{})
+		}
+		space := q.space
+		q.Unlock()
+
+		select {
+		case <-space:
+		case <-expired:
+			return ErrSendTimeout
+		}
+	}
+}
+
+// dropOldest queues the data packet, the oldest data packet will be dropped
+// when the queue is full, returns whether a packet has been dropped
+func (q *sendQueue) dropOldest(data []byte) (bool, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return false, ErrSendChannelClosed
+	}
+	if !q.full(false) {
+		q.append(data, false)
+		return false, nil
+	}
+
+	for i, p := range q.packets {
+		if p.control {
+			continue
+		}
+		n := copy(q.packets[i:], q.packets[i+1:])
+		q.packets[i+n] = queuedPacket{}
+		q.packets = q.packets[:i+n]
+		q.data--
+		q.append(data, false)
+		return true, nil
+	}
+	return false, ErrSendQueueFull
+}
+
+// pop dequeues the oldest packet, false will be returned when the queue is
+// empty, only called by the writer
+func (q *sendQueue) pop() ([]byte, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.packets) == 0 {
+		return nil, false
+	}
+	p := q.packets[0]
+	q.packets[0] = queuedPacket{}
+	q.packets = q.packets[1:]
+	if !p.control {
+		q.data--
+	}
+
+	// wake up the blocked senders
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+	return p.data, true
+}
+
+// close drops all queued packets, and wakes up the blocked senders, packets
+// could not be queued any more
+func (q *sendQueue) close() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.closed = true
+	q.packets = nil
+	q.data = 0
+	if q.space != nil {
+		close(q.space)
+		q.space = nil
+	}
+}
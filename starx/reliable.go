@@ -0,0 +1,316 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/session"
+)
+
+var (
+	ErrUserOffline = errors.New("user not online and no offline store")
+	ErrOutboxFull  = errors.New("too many unacked reliable pushes")
+
+	errOutboxClosed = errors.New("outbox closed")
+)
+
+// max count of unacked reliable pushes of a session by default
+const defaultMaxPendingPushes = 1024
+
+// OfflineMessage represents a reliable push stored for the user which is
+// not online
+type OfflineMessage struct {
+	Route string
+	Data  []byte
+}
+
+// OfflineStore stores reliable pushes for users which are not online, stored
+// messages will be delivered after the user bound to a new session
+type OfflineStore interface {
+	// Save appends message to the store of user
+	Save(uid int64, m *OfflineMessage) error
+
+	// Load returns all stored messages of user in order, and removes them
+	// from the store
+	Load(uid int64) ([]*OfflineMessage, error)
+}
+
+// MemoryStore is an OfflineStore keeps messages in process memory, messages
+// will be lost when process exits
+type MemoryStore struct {
+	sync.Mutex
+	messages map[int64][]*OfflineMessage
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: make(map[int64][]*OfflineMessage)}
+}
+
+func (ms *MemoryStore) Save(uid int64, m *OfflineMessage) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.messages[uid] = append(ms.messages[uid], m)
+	return nil
+}
+
+func (ms *MemoryStore) Load(uid int64) ([]*OfflineMessage, error) {
+	ms.Lock()
+	defer ms.Unlock()
+
+	messages := ms.messages[uid]
+	delete(ms.messages, uid)
+	return messages, nil
+}
+
+// outbox retains the reliable pushes of a session until client acks, the
+// outbox belongs to session, so it will be kept when session resumed
+type outbox struct {
+	sync.Mutex
+	seq     uint                  // last sequence number
+	pending []*message.Message    // unacked pushes in sequence order
+	entity  session.NetworkEntity // agent which pending pushes replayed to
+	closed  bool                  // session has been closed
+	uid     int64                 // uid bound to session
+
+	// sending serializes sequence assignment and sending, pushes are
+	// queued in sequence order and never overtake replayed ones, it is
+	// separated from the outbox lock because sending may block when the
+	// send queue is full
+	sending sync.Mutex
+}
+
+// pushReliable appends message to the outbox of session, and sends it when
+// pending pushes have been replayed to current agent, message will be saved
+// to offline store when session has been closed, the session will be kicked
+// when client does not ack in time and too many pushes are pending
+func (t *transportService) pushReliable(s *session.Session, route string, data []byte) error {
+	t.RLock()
+	ob, ok := t.outboxes[s.ID]
+	t.RUnlock()
+	if !ok {
+		return t.saveOffline(s.Uid, route, data)
+	}
+
+	ob.sending.Lock()
+	ep, err := ob.push(s, route, data)
+	if err == nil && ep != nil {
+		if err := t.send(s, ep); err != nil {
+			log.Infof("Reliable push retained, Id=%d, Error=%s", s.ID, err.Error())
+		}
+	}
+	ob.sending.Unlock()
+
+	if err == errOutboxClosed {
+		return t.saveOffline(s.Uid, route, data)
+	}
+	if err == ErrOutboxFull {
+		log.Infof("Too many unacked reliable pushes, session will be kicked, Id=%d, Uid=%d", s.ID, s.Uid)
+		if err := s.Kick(ErrOutboxFull.Error()); err != nil {
+			s.Close()
+		}
+	}
+	return err
+}
+
+// push appends message to outbox, returns the packet which should be sent to
+// current agent, nil packet will be returned when message retained for replay
+func (ob *outbox) push(s *session.Session, route string, data []byte) ([]byte, error) {
+	ob.Lock()
+	defer ob.Unlock()
+
+	if ob.closed {
+		return nil, errOutboxClosed
+	}
+	if len(ob.pending) >= env.maxPendingPushes {
+		return nil, ErrOutboxFull
+	}
+
+	m := &message.Message{
+		Type:  message.MessageType(message.ReliablePush),
+		ID:    ob.seq + 1,
+		Route: route,
+		Data:  data,
+	}
+	ep, err := packReliable(s, m)
+	if err != nil {
+		return nil, err
+	}
+	ob.seq = m.ID
+	ob.pending = append(ob.pending, m)
+
+	// message will be replayed after client reconnected
+	if ob.entity != s.NetworkEntity() {
+		return nil, nil
+	}
+	return ep, nil
+}
+
+// packReliable packs a copy of message, the deflate option depends on the
+// wire protocol of current agent
+func packReliable(s *session.Session, m *message.Message) ([]byte, error) {
+	cm := *m
+	return packMessage(s, &cm)
+}
+
+// replay sends all pending pushes of session in order after handshake
+// completed, the following pushes will be sent directly
+func (t *transportService) replay(a *agent) {
+	s := a.Session()
+	t.RLock()
+	ob, ok := t.outboxes[s.ID]
+	t.RUnlock()
+	if !ok {
+		return
+	}
+
+	ob.sending.Lock()
+	defer ob.sending.Unlock()
+
+	ob.Lock()
+	ob.entity = a
+	packets := make([][]byte, 0, len(ob.pending))
+	seqs := make([]uint, 0, len(ob.pending))
+	for _, m := range ob.pending {
+		ep, err := packReliable(s, m)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
+		}
+		packets = append(packets, ep)
+		seqs = append(seqs, m.ID)
+	}
+	ob.Unlock()
+
+	for i, ep := range packets {
+		if err := a.Send(ep); err != nil {
+			log.Infof("Replay reliable push failed, Id=%d, Seq=%d, Error=%s", s.ID, seqs[i], err.Error())
+			return
+		}
+	}
+}
+
+// ack removes all pending pushes whose sequence number not greater than id
+func (t *transportService) ack(s *session.Session, id uint) {
+	t.RLock()
+	ob, ok := t.outboxes[s.ID]
+	t.RUnlock()
+	if !ok {
+		return
+	}
+
+	ob.Lock()
+	defer ob.Unlock()
+
+	i := 0
+	for i < len(ob.pending) && ob.pending[i].ID <= id {
+		i++
+	}
+	n := copy(ob.pending, ob.pending[i:])
+	for j := n; j < len(ob.pending); j++ {
+		ob.pending[j] = nil
+	}
+	ob.pending = ob.pending[:n]
+}
+
+// bind records the online session of user, and delivers the messages stored
+// when user offline
+func (t *transportService) bind(s *session.Session) {
+	t.Lock()
+	if ob, ok := t.outboxes[s.ID]; ok {
+		// remove the uid bound before, session is rebound to another uid
+		ob.Lock()
+		if ob.uid != s.Uid && t.uids[ob.uid] == s {
+			delete(t.uids, ob.uid)
+		}
+		ob.uid = s.Uid
+		ob.Unlock()
+	}
+	t.uids[s.Uid] = s
+	t.Unlock()
+
+	store := env.offlineStore
+	if store == nil {
+		return
+	}
+
+	messages, err := store.Load(s.Uid)
+	if err != nil {
+		log.Errorf("Load offline messages failed, Uid=%d, Error=%s", s.Uid, err.Error())
+		return
+	}
+	for _, m := range messages {
+		if err := t.pushReliable(s, m.Route, m.Data); err != nil {
+			log.Errorf("Deliver offline message failed, Uid=%d, Route=%s, Error=%s", s.Uid, m.Route, err.Error())
+		}
+	}
+}
+
+// pushUser pushes message to the online session of user reliably, message
+// will be saved to offline store when user not online
+func (t *transportService) pushUser(uid int64, route string, data []byte) error {
+	t.RLock()
+	s, ok := t.uids[uid]
+	t.RUnlock()
+	if !ok {
+		return t.saveOffline(uid, route, data)
+	}
+	return t.pushReliable(s, route, data)
+}
+
+func (t *transportService) saveOffline(uid int64, route string, data []byte) error {
+	store := env.offlineStore
+	if store == nil || uid < 1 {
+		return ErrUserOffline
+	}
+	return store.Save(uid, &OfflineMessage{Route: route, Data: data})
+}
+
+// closeOutbox removes the outbox of closed session, all pending pushes will
+// be saved to offline store when session has been bound
+func (t *transportService) closeOutbox(s *session.Session) {
+	t.Lock()
+	ob, ok := t.outboxes[s.ID]
+	delete(t.outboxes, s.ID)
+	if t.uids[s.Uid] == s {
+		delete(t.uids, s.Uid)
+	}
+	t.Unlock()
+	if !ok {
+		return
+	}
+
+	ob.Lock()
+	pending := ob.pending
+	ob.pending = nil
+	ob.closed = true
+	ob.Unlock()
+
+	for _, m := range pending {
+		if err := t.saveOffline(s.Uid, m.Route, m.Data); err != nil {
+			log.Infof("Reliable push dropped, Id=%d, Uid=%d, Seq=%d, Error=%s", s.ID, s.Uid, m.ID, err.Error())
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package starx
+
+import (
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/session"
+)
+
+func reliableSeq(t *testing.T, a *agent) uint {
+	data, ok := a.queue.pop()
+	if !ok {
+		t.Fatal("no packet sent")
+	}
+	p, _, err := codec.Default.Unpack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != packet.Data {
+		t.Fatalf("wrong packet type: %d", p.Type)
+	}
+	m, err := codec.Default.Decode(p.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != message.ReliablePush {
+		t.Fatalf("wrong message type: %d", m.Type)
+	}
+	return m.ID
+}
+
+func TestReliablePush(t *testing.T) {
+	defer func(s OfflineStore) { env.offlineStore = s }(env.offlineStore)
+	store := NewMemoryStore()
+	env.offlineStore = store
+
+	ts := newTransporter()
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	ts.agents[a.id] = a
+	ts.outboxes[a.id] = &outbox{}
+	a.session.Uid = 100
+
+	// retained before handshake completed
+	for i := 0; i < 3; i++ {
+		if err := ts.pushReliable(a.session, "test.reliable", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.queueDepth() != 0 {
+		t.Fatal("push should be retained before replay")
+	}
+
+	ts.replay(a)
+	for i := uint(1); i <= 3; i++ {
+		if seq := reliableSeq(t, a); seq != i {
+			t.Fatalf("expect seq %d, got %d", i, seq)
+		}
+	}
+
+	if err := ts.pushReliable(a.session, "test.reliable", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if seq := reliableSeq(t, a); seq != 4 {
+		t.Fatalf("expect seq 4, got %d", seq)
+	}
+
+	ts.ack(a.session, 2)
+	if n := len(ts.outboxes[a.id].pending); n != 2 {
+		t.Fatalf("expect 2 pending pushes, got %d", n)
+	}
+
+	// unacked pushes will be saved to offline store
+	ts.closeOutbox(a.session)
+	if n := len(store.messages[100]); n != 2 {
+		t.Fatalf("expect 2 offline messages, got %d", n)
+	}
+	if err := ts.pushUser(100, "test.reliable", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// delivered after user bound to new session
+	conn2, _ := net.Pipe()
+	a2 := newAgent(conn2)
+	ts.agents[a2.id] = a2
+	ts.outboxes[a2.id] = &outbox{}
+	a2.session.Uid = 100
+	ts.bind(a2.session)
+	ts.replay(a2)
+	for i := uint(1); i <= 3; i++ {
+		if seq := reliableSeq(t, a2); seq != i {
+			t.Fatalf("expect seq %d, got %d", i, seq)
+		}
+	}
+	if len(store.messages[100]) != 0 {
+		t.Fatal("offline messages should be removed")
+	}
+
+	env.offlineStore = nil
+	if err := ts.pushUser(200, "test.reliable", nil); err != ErrUserOffline {
+		t.Fatalf("expect ErrUserOffline, got %v", err)
+	}
+}
+
+func TestReliablePushLimit(t *testing.T) {
+	defer func(n int) { env.maxPendingPushes = n }(env.maxPendingPushes)
+	env.maxPendingPushes = 2
+	SetSerializer(json.NewSerializer())
+
+	ts := newTransporter()
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	ts.agents[a.id] = a
+	ts.outboxes[a.id] = &outbox{}
+	ts.replay(a)
+
+	for i := uint(1); i <= 2; i++ {
+		if err := ts.pushReliable(a.session, "test.reliable", []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if seq := reliableSeq(t, a); seq != i {
+			t.Fatalf("expect seq %d, got %d", i, seq)
+		}
+	}
+
+	// client does not ack, session is kicked when outbox is full
+	if err := ts.pushReliable(a.session, "test.reliable", []byte("hello")); err != ErrOutboxFull {
+		t.Fatalf("expect ErrOutboxFull, got %v", err)
+	}
+	data, ok := a.queue.pop()
+	if !ok {
+		t.Fatal("session should be kicked")
+	}
+	if packet.PacketType(data[0]) != packet.Kick {
+		t.Errorf("expect kick packet, got type %d", data[0])
+	}
+}
+
+// yieldEntity yields the processor random times before sending, concurrent
+// pushes are likely to be interleaved
+type yieldEntity struct {
+	*agent
+}
+
+func (e yieldEntity) Send(data []byte) error {
+	for i := rand.Intn(3); i > 0; i-- {
+		runtime.Gosched()
+	}
+	return e.agent.Send(data)
+}
+
+func TestReliablePushOrder(t *testing.T) {
+	ts := newTransporter()
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	s := session.New(yieldEntity{a})
+	ts.outboxes[s.ID] = &outbox{entity: s.NetworkEntity()}
+
+	// client receives concurrent pushes in sequence order
+	const count = 100
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ts.pushReliable(s, "test.reliable", []byte("hello")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := uint(1); i <= count; i++ {
+		if seq := reliableSeq(t, a); seq != i {
+			t.Fatalf("expect seq %d, got %d", i, seq)
+		}
+	}
+}
+
+func TestReliableRebind(t *testing.T) {
+	ts := newTransporter()
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	ts.agents[a.id] = a
+	ts.outboxes[a.id] = &outbox{}
+
+	a.session.Uid = 100
+	ts.bind(a.session)
+	a.session.Uid = 200
+	ts.bind(a.session)
+
+	// the uid bound before is removed
+	if _, ok := ts.uids[100]; ok {
+		t.Error("uid bound before should be removed")
+	}
+	if ts.uids[200] != a.session {
+		t.Error("session should be bound to new uid")
+	}
+}
@@ -28,10 +28,12 @@ import (
 	"os"
 	"reflect"
 	"runtime/debug"
+	"sync"
 
 	"github.com/chrislonng/starx/cluster/rpc"
 	"github.com/chrislonng/starx/component"
 	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/packet"
 	"github.com/chrislonng/starx/route"
 )
 
@@ -39,11 +41,8 @@ var remote = newRemote()
 
 type remoteService struct {
 	serviceMap map[string]*component.Service // all handler service
-}
-
-type unhandledRequest struct {
-	bs *acceptor
-	rr *rpc.Request
+	poolOnce   sync.Once
+	workers    *workerPool // requests of the same session are handled in order
 }
 
 func newRemote() *remoteService {
@@ -82,57 +81,56 @@ func (rs *remoteService) handle(conn net.Conn) {
 	var acceptor *acceptor
 	defer func() {
 		conn.Close()
-		if nil != acceptor{
-			for _, v := range acceptor.sessionMap {
+		if nil != acceptor {
+			for _, v := range acceptor.sessions() {
 				FindConnLostCallBack(v.BelongToComponent)(v.ID)
 			}
 		}
 	}()
-	// message buffer
-	requestChan := make(chan *unhandledRequest, packetBufferSize)
-	endChan := make(chan bool, 1)
-	// all user logic will be handled in single goroutine
-	// synchronized in below routine
-	go func() {
-		for {
-			select {
-			case r := <-requestChan:
-				rs.processRequest(r.bs, r.rr)
-			case <-endChan:
-				close(requestChan)
-				return
-			}
-		}
-	}()
-
 	acceptor = transporter.createAcceptor(conn)
 	transporter.dumpAcceptor()
-	tmp := make([]byte, 0) // save truncated data
-	buf := make([]byte, 512)
+	dec := packet.NewDecoder(conn, 0)
 	for {
-		n, err := conn.Read(buf)
+		p, err := dec.Decode(rpc.Framing)
 		if err != nil {
 			log.Infof("session closed(" + err.Error() + ")")
 			transporter.dumpAcceptor()
 			acceptor.Close()
-			endChan <- true
 			break
 		}
-		tmp = append(tmp, buf[:n]...)
-		// TODO
-		// Refactor this loop
-		// read all request from buffer, and send to handle queue
-		for {
-			rr := &rpc.Request{} // save decoded packet
-			if tmp, err = rr.UnmarshalMsg(tmp); err != nil {
-				break
-			} else {
-				requestChan <- &unhandledRequest{acceptor, rr}
-			}
+
+		rr := &rpc.Request{} // save decoded packet
+		_, err = rr.UnmarshalMsg(p.Data)
+		packet.Release(p)
+		if err != nil {
+			log.Errorf(err.Error())
+			continue
 		}
+		rs.dispatch(acceptor, rr)
 	}
 }
 
+// pool returns the worker pool, which will be created at first request
+func (rs *remoteService) pool() *workerPool {
+	rs.poolOnce.Do(func() {
+		rs.workers = newWorkerPool(env.workerCount, packetBufferSize)
+	})
+	return rs.workers
+}
+
+// dispatch queues the request to worker pool, requests of the same frontend
+// session are handled in order, and different sessions in parallel
+func (rs *remoteService) dispatch(ac *acceptor, rr *rpc.Request) {
+	// in-flight calls contain queued requests, which should be drained
+	// before shutdown
+	beginCall()
+	key := uint64(ac.id)<<32 ^ uint64(rr.Sid)
+	rs.pool().submit(key, func() {
+		defer endCall()
+		rs.processRequest(ac, rr)
+	})
+}
+
 func isSessionClosedRequest(rr *rpc.Request) bool {
 	return rr.ServiceMethod == sessionClosedRoute
 }
@@ -162,6 +160,7 @@ func (rs *remoteService) processRequest(ac *acceptor, rr *rpc.Request) {
 	if err != nil {
 		log.Errorf(err.Error())
 		response.Error = err.Error()
+		response.Code = CodeBadRequest
 		goto WRITE_RESPONSE
 	}
 
@@ -170,16 +169,27 @@ func (rs *remoteService) processRequest(ac *acceptor, rr *rpc.Request) {
 		str := "remote: servive " + route.Service + " does not exists"
 		log.Errorf(str)
 		response.Error = str
+		response.Code = CodeRouteNotFound
 		goto WRITE_RESPONSE
 	}
 
 	switch rr.Kind {
-	case rpc.Sys:
+	case rpc.Sys, rpc.SysNotify:
 		m, ok := service.HandlerMethods[route.Method]
 		if !ok || m == nil {
 			str := "remote: service " + route.Service + "does not contain method: " + route.Method
 			log.Errorf(str)
 			response.Error = str
+			response.Code = CodeRouteNotFound
+			goto WRITE_RESPONSE
+		}
+		// notify has no response, so it can not be handled by the handler
+		// which returns response
+		if m.Reply && rr.Kind == rpc.SysNotify {
+			str := "remote: handler " + route.Service + "." + route.Method + " returns response, can not handle notify"
+			log.Errorf(str)
+			response.Error = str
+			response.Code = CodeBadRequest
 			goto WRITE_RESPONSE
 		}
 		var data interface{}
@@ -192,22 +202,32 @@ func (rs *remoteService) processRequest(ac *acceptor, rr *rpc.Request) {
 				str := "deserialize error: " + err.Error()
 				log.Errorf(str)
 				response.Error = str
+				response.Code = CodeBadRequest
 				goto WRITE_RESPONSE
 			}
 		}
 
-		ret, err := rs.call(m.Method, []reflect.Value{
-			service.Rcvr,
-			reflect.ValueOf(session),
-			reflect.ValueOf(data)})
+		resp, err := filterHandler(session, route, data, func() (interface{}, error) {
+			ret, err := rs.call(m.Method, []reflect.Value{
+				service.Rcvr,
+				reflect.ValueOf(session),
+				reflect.ValueOf(data)})
+			if err != nil {
+				return nil, err
+			}
+			return handlerResult(m, ret)
+		})
+		// typed error keeps its code, which will be responded to client
+		// by frontend server
 		if err != nil {
 			log.Errorf(err.Error())
-			response.Error = err.Error()
-		} else {
-			// handler method encounter error
-			if err := ret[0].Interface(); err != nil {
-				log.Errorf(err.(error).Error())
-				response.Error = err.(error).Error()
+			e := toError(err)
+			response.Error = e.Msg
+			response.Code = int(e.Code)
+		} else if resp = replyOf(m, resp); resp != nil {
+			// response will be forwarded to client by frontend server
+			if err := session.Response(resp); err != nil {
+				log.Errorf(err.Error())
 			}
 		}
 	case rpc.User:
@@ -247,7 +267,7 @@ func (rs *remoteService) processRequest(ac *acceptor, rr *rpc.Request) {
 	}
 
 WRITE_RESPONSE:
-	if err := rpc.WriteResponse(ac.socket, response); err != nil {
+	if err := ac.write(response); err != nil {
 		log.Errorf(err.Error())
 	}
 }
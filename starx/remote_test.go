@@ -0,0 +1,85 @@
+package starx
+
+import (
+	"net"
+	"testing"
+
+	"github.com/chrislonng/starx/cluster/rpc"
+	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/serialize/json"
+)
+
+// readResponse reads a rpc response written by acceptor
+func readResponse(t *testing.T, dec *packet.Decoder) *rpc.Response {
+	p, err := dec.Decode(rpc.Framing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &rpc.Response{}
+	if _, err := resp.UnmarshalMsg(p.Data); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRemoteNotifyReply(t *testing.T) {
+	SetSerializer(json.NewSerializer())
+	rs := newRemote()
+	if err := rs.register(&TestComp{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := serializeOrRaw(JsonMessage{Code: 1, Data: "hello world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	ac := transporter.createAcceptor(server)
+	defer transporter.removeAcceptor(ac)
+	dec := packet.NewDecoder(client, 0)
+
+	// request is handled, handler response and rpc response are written
+	go rs.processRequest(ac, &rpc.Request{
+		ServiceMethod: "TestComp.HandleReply",
+		Seq:           1,
+		Sid:           1,
+		Data:          data,
+		Kind:          rpc.Sys,
+	})
+	if resp := readResponse(t, dec); resp.Kind != rpc.HandlerResponse || resp.Sid != 1 {
+		t.Fatalf("wrong handler response: %+v", resp)
+	}
+	if resp := readResponse(t, dec); resp.Kind != rpc.RemoteResponse || resp.Seq != 1 || resp.Error != "" {
+		t.Fatalf("wrong rpc response: %+v", resp)
+	}
+
+	// empty handler response is written when handler returns nothing
+	go rs.processRequest(ac, &rpc.Request{
+		ServiceMethod: "TestComp.HandleEmptyReply",
+		Seq:           3,
+		Sid:           1,
+		Data:          data,
+		Kind:          rpc.Sys,
+	})
+	if resp := readResponse(t, dec); resp.Kind != rpc.HandlerResponse || resp.Sid != 1 || len(resp.Data) != 0 {
+		t.Fatalf("wrong empty handler response: %+v", resp)
+	}
+	if resp := readResponse(t, dec); resp.Kind != rpc.RemoteResponse || resp.Seq != 3 || resp.Error != "" {
+		t.Fatalf("wrong rpc response: %+v", resp)
+	}
+
+	// notify is rejected before handler called
+	go rs.processRequest(ac, &rpc.Request{
+		ServiceMethod: "TestComp.HandleReply",
+		Seq:           2,
+		Sid:           1,
+		Data:          data,
+		Kind:          rpc.SysNotify,
+	})
+	resp := readResponse(t, dec)
+	if resp.Kind != rpc.RemoteResponse || resp.Seq != 2 || resp.Code != CodeBadRequest {
+		t.Fatalf("notify should be rejected, got: %+v", resp)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package starx
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/chrislonng/starx/log"
+)
+
+// inheritEnv contains the keys of listeners which passed to the new process
+// on graceful restart, the listener files start from fd 3 in the same order
+const inheritEnv = "STARX_INHERIT_LISTENERS"
+
+type rawListener struct {
+	key string // listener config string, e.g. tcp://0.0.0.0:3250
+	l   net.Listener
+}
+
+var (
+	rawListenersLock sync.Mutex
+	rawListeners     []rawListener // raw listeners of current process
+
+	inheritOnce sync.Once
+	inherited   map[string]net.Listener // listeners inherited from parent process
+)
+
+// addRawListener tracks the raw listener which will be passed to the new
+// process on graceful restart
+func addRawListener(key string, l net.Listener) {
+	rawListenersLock.Lock()
+	defer rawListenersLock.Unlock()
+
+	rawListeners = append(rawListeners, rawListener{key: key, l: l})
+}
+
+// inheritedListener returns the listener inherited from parent process,
+// nil will be returned when not found
+func inheritedListener(key string) net.Listener {
+	inheritOnce.Do(loadInherited)
+
+	rawListenersLock.Lock()
+	defer rawListenersLock.Unlock()
+
+	l, ok := inherited[key]
+	if !ok {
+		return nil
+	}
+	delete(inherited, key)
+	return l
+}
+
+func loadInherited() {
+	inherited = make(map[string]net.Listener)
+
+	keys := os.Getenv(inheritEnv)
+	if keys == "" {
+		return
+	}
+	os.Unsetenv(inheritEnv)
+
+	for i, key := range strings.Split(keys, ",") {
+		f := os.NewFile(uintptr(3+i), key)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Errorf("Inherit listener %s failed, Error=%s", key, err.Error())
+			continue
+		}
+		inherited[key] = l
+	}
+}
+
+// restart starts a new process with the same arguments, and passes all raw
+// listeners to the new process
+func restart() error {
+	rawListenersLock.Lock()
+	defer rawListenersLock.Unlock()
+
+	var (
+		keys  []string
+		files []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, rl := range rawListeners {
+		fl, ok := rl.l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			continue
+		}
+
+		// unix socket file should be kept for the new process
+		if ul, ok := rl.l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, rl.key)
+		files = append(files, f)
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), inheritEnv+"="+strings.Join(keys, ","))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	log.Infof("New process started, Pid=%d, Listeners=%v", cmd.Process.Pid, keys)
+	return nil
+}
+
+// watchRestart starts a new process when SIGUSR2 received, and then current
+// process stops accepting and drains existing connections, clients are kicked
+// only when they are still connected after shutdown timeout
+func watchRestart() {
+	sg := make(chan os.Signal, 1)
+	signal.Notify(sg, syscall.SIGUSR2)
+
+	for range sg {
+		if isShuttingDown() {
+			return
+		}
+
+		if err := restart(); err != nil {
+			log.Errorf("Graceful restart failed, Error=%s", err.Error())
+			continue
+		}
+
+		stop(false)
+		return
+	}
+}
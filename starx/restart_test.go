@@ -0,0 +1,79 @@
+//go:build !windows
+// +build !windows
+
+package starx
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const restartTestKey = "tcp://restart-test"
+
+// TestRestartChild runs in the process started by TestRestart, it accepts a
+// connection from the inherited listener
+func TestRestartChild(t *testing.T) {
+	if os.Getenv(inheritEnv) == "" {
+		t.Skip("only run in restarted process")
+	}
+
+	l := inheritedListener(restartTestKey)
+	if l == nil {
+		t.Fatal("listener not inherited")
+	}
+	defer l.Close()
+
+	// do not leave the process behind when parent test failed
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("child"))
+	conn.Close()
+}
+
+func TestRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addRawListener(restartTestKey, l)
+	defer func() {
+		rawListenersLock.Lock()
+		rawListeners = nil
+		rawListenersLock.Unlock()
+	}()
+
+	// new process only runs the child test, and its output is discarded
+	defer func(args []string, stdout *os.File) {
+		os.Args, os.Stdout = args, stdout
+	}(os.Args, os.Stdout)
+	os.Args = []string{os.Args[0], "-test.run=^TestRestartChild$"}
+	if os.Stdout, err = os.Open(os.DevNull); err != nil {
+		t.Fatal(err)
+	}
+	err = restart()
+	os.Stdout.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// connection is accepted by the new process after current process
+	// stopped accepting
+	addr := l.Addr().String()
+	l.Close()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "child" {
+		t.Fatalf("connection not accepted by new process, Error=%v", err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/chrislonng/starx/log"
+	"github.com/chrislonng/starx/session"
+)
+
+// Length of random bytes in resume token
+const resumeTokenLength = 16
+
+// suspendedSession represents a session whose connection has been lost, it
+// could be resumed by a new connection with the resume token before expired
+type suspendedSession struct {
+	session *session.Session
+	timer   *time.Timer
+}
+
+func newResumeToken() (string, error) {
+	b := make([]byte, resumeTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// suspend keeps the session of the lost agent for the grace period, session
+// closed callbacks will be called when it has not been resumed in time
+func (t *transportService) suspend(a *agent) {
+	t.Lock()
+	defer t.Unlock()
+
+	id, s := a.ID(), a.Session()
+	if agent, ok := t.agents[id]; ok && agent == a {
+		delete(t.agents, id)
+	}
+
+	token := a.resumeToken
+	ss := &suspendedSession{session: s}
+	ss.timer = time.AfterFunc(env.resumeGrace, func() {
+		t.expire(token, ss)
+	})
+	t.suspended[token] = ss
+
+	log.Debugf("Session suspended, Id=%d, Grace=%s", s.ID, env.resumeGrace)
+}
+
+// expire closes the suspended session which has not been resumed
+func (t *transportService) expire(token string, ss *suspendedSession) {
+	t.Lock()
+	if t.suspended[token] != ss {
+		t.Unlock()
+		return
+	}
+	delete(t.suspended, token)
+	t.Unlock()
+
+	s := ss.session
+	log.Debugf("Suspended session expired, Id=%d", s.ID)
+	FindConnLostCallBack(s.BelongToComponent)(s.ID)
+	t.closeSession(s)
+}
+
+// resume reattaches the agent to the suspended session of the token, agent
+// takes the session id, so backend servers keep the session affinity
+func (t *transportService) resume(a *agent, token string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	ss, ok := t.suspended[token]
+	if !ok {
+		return false
+	}
+	delete(t.suspended, token)
+	ss.timer.Stop()
+
+	id := a.ID()
+	if agent, ok := t.agents[id]; ok && agent == a {
+		delete(t.agents, id)
+	}
+	delete(t.outboxes, id)
+
+	s := ss.session
+	a.attach(s)
+	s.SetNetworkEntity(a)
+	t.agents[s.ID] = a
+
+	log.Debugf("Session resumed, Id=%d, Remote=%s", s.ID, a.socket.RemoteAddr())
+	return true
+}
+
+// isSuspended reports whether the session of agent is waiting for resuming
+func (t *transportService) isSuspended(a *agent) bool {
+	t.RLock()
+	defer t.RUnlock()
+
+	ss, ok := t.suspended[a.resumeToken]
+	return ok && ss.session == a.Session()
+}
+
+// closeSuspended closes all suspended sessions immediately
+func (t *transportService) closeSuspended() {
+	t.Lock()
+	suspended := t.suspended
+	t.suspended = make(map[string]*suspendedSession)
+	t.Unlock()
+
+	for _, ss := range suspended {
+		ss.timer.Stop()
+		FindConnLostCallBack(ss.session.BelongToComponent)(ss.session.ID)
+		t.closeSession(ss.session)
+	}
+}
@@ -1,18 +1,126 @@
 package service
 
 import (
+	"errors"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var (
+	ErrTooManyConnections      = errors.New("too many connections")
+	ErrTooManyConnectionsPerIP = errors.New("too many connections from the ip")
+	ErrConnectionRateLimited   = errors.New("connection rate limited")
+)
+
+// Sweep idle ip entries when the ip map grows larger than the threshold
+const ipSweepThreshold = 1024
+
 var Connections = newConnectionService()
 
 type connectionService struct {
-	count int64
-	sid   int64
+	count    int64
+	sid      int64
+	rejected int64
+
+	mu        sync.Mutex // protect following
+	maxConns  int64      // max connections, 0 means unlimited
+	maxPerIP  int        // max connections per ip, 0 means unlimited
+	rateLimit int        // max new connections per ip per second, 0 means unlimited
+	ips       map[string]*ipConnections
+	lastSweep time.Time
+}
+
+// ipConnections represents the connection state of an ip
+type ipConnections struct {
+	count  int   // current connections
+	window int64 // unix second of current rate window
+	rate   int   // new connections in current rate window
 }
 
 func newConnectionService() *connectionService {
-	return &connectionService{sid: 0}
+	return &connectionService{
+		sid: 0,
+		ips: make(map[string]*ipConnections),
+	}
+}
+
+// SetLimits set max connections, max connections per ip and max new
+// connections per ip per second, 0 means unlimited
+func (c *connectionService) SetLimits(maxConns, maxPerIP, rateLimit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxConns = int64(maxConns)
+	c.maxPerIP = maxPerIP
+	c.rateLimit = rateLimit
+}
+
+// Acquire admits a new connection from the ip, the connection count will be
+// increased when admitted, and should be released by Release when closed
+func (c *connectionService) Acquire(ip string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+
+	ic, ok := c.ips[ip]
+	if !ok {
+		ic = &ipConnections{}
+		c.ips[ip] = ic
+	}
+
+	// new connections of rejected sockets are counted in rate window too
+	if sec := now.Unix(); ic.window != sec {
+		ic.window = sec
+		ic.rate = 0
+	}
+	ic.rate++
+
+	var err error
+	switch {
+	case c.rateLimit > 0 && ic.rate > c.rateLimit:
+		err = ErrConnectionRateLimited
+	case c.maxPerIP > 0 && ic.count >= c.maxPerIP:
+		err = ErrTooManyConnectionsPerIP
+	case c.maxConns > 0 && c.Count() >= c.maxConns:
+		err = ErrTooManyConnections
+	}
+	if err != nil {
+		atomic.AddInt64(&c.rejected, 1)
+		return err
+	}
+
+	ic.count++
+	c.Increment()
+	return nil
+}
+
+// Release the connection which admitted by Acquire
+func (c *connectionService) Release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ic, ok := c.ips[ip]; ok && ic.count > 0 {
+		ic.count--
+	}
+	c.Decrement()
+}
+
+// sweep removes entries of ips without connections and out of rate window
+func (c *connectionService) sweep(now time.Time) {
+	if len(c.ips) < ipSweepThreshold || now.Sub(c.lastSweep) < time.Second {
+		return
+	}
+	c.lastSweep = now
+
+	sec := now.Unix()
+	for ip, ic := range c.ips {
+		if ic.count == 0 && ic.window != sec {
+			delete(c.ips, ip)
+		}
+	}
 }
 
 func (c *connectionService) Increment() {
@@ -27,9 +135,30 @@ func (c *connectionService) Count() int64 {
 	return atomic.LoadInt64(&c.count)
 }
 
+// CountOf returns current connection count of the ip
+func (c *connectionService) CountOf(ip string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ic, ok := c.ips[ip]; ok {
+		return ic.count
+	}
+	return 0
+}
+
+// Rejected returns the count of connections rejected by admission control
+func (c *connectionService) Rejected() int64 {
+	return atomic.LoadInt64(&c.rejected)
+}
+
 func (c *connectionService) Reset() {
+	c.mu.Lock()
+	c.ips = make(map[string]*ipConnections)
+	c.mu.Unlock()
+
 	atomic.StoreInt64(&c.count, 0)
 	atomic.StoreInt64(&c.sid, 0)
+	atomic.StoreInt64(&c.rejected, 0)
 }
 
 func (c *connectionService) SessionID() int64 {
@@ -29,3 +29,55 @@ func TestNewConnectionService(t *testing.T) {
 		t.Error("wrong session id")
 	}
 }
+
+func TestConnectionService_Acquire(t *testing.T) {
+	service := newConnectionService()
+	service.SetLimits(3, 2, 0)
+
+	if err := service.Acquire("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Acquire("10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Acquire("10.0.0.1"); err != ErrTooManyConnectionsPerIP {
+		t.Fatalf("expect ErrTooManyConnectionsPerIP, got %v", err)
+	}
+	if err := service.Acquire("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Acquire("10.0.0.3"); err != ErrTooManyConnections {
+		t.Fatalf("expect ErrTooManyConnections, got %v", err)
+	}
+
+	if service.Count() != 3 || service.CountOf("10.0.0.1") != 2 || service.Rejected() != 2 {
+		t.Fatalf("wrong count, Count=%d, CountOf=%d, Rejected=%d",
+			service.Count(), service.CountOf("10.0.0.1"), service.Rejected())
+	}
+
+	service.Release("10.0.0.1")
+	if err := service.Acquire("10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectionService_RateLimit(t *testing.T) {
+	service := newConnectionService()
+	service.SetLimits(0, 0, 2)
+
+	for i := 0; i < 2; i++ {
+		if err := service.Acquire("10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		service.Release("10.0.0.1")
+	}
+
+	// the rate window may be changed between acquires
+	err := service.Acquire("10.0.0.1")
+	if err != nil && err != ErrConnectionRateLimited {
+		t.Fatalf("expect ErrConnectionRateLimited, got %v", err)
+	}
+	if err := service.Acquire("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"sync/atomic"
+)
+
+// Stats collects counters of the network layer
+var Stats = newStatsService()
+
+type statsService struct {
+	oversizedPackets int64 // inbound packets rejected by max packet size
+	droppedPackets   int64 // outbound packets dropped by send queue overflow
+}
+
+func newStatsService() *statsService {
+	return &statsService{}
+}
+
+func (s *statsService) IncrementOversizedPackets() {
+	atomic.AddInt64(&s.oversizedPackets, 1)
+}
+
+func (s *statsService) OversizedPackets() int64 {
+	return atomic.LoadInt64(&s.oversizedPackets)
+}
+
+func (s *statsService) IncrementDroppedPackets() {
+	atomic.AddInt64(&s.droppedPackets, 1)
+}
+
+func (s *statsService) DroppedPackets() int64 {
+	return atomic.LoadInt64(&s.droppedPackets)
+}
+
+func (s *statsService) Reset() {
+	atomic.StoreInt64(&s.oversizedPackets, 0)
+	atomic.StoreInt64(&s.droppedPackets, 0)
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestStatsService_OversizedPackets(t *testing.T) {
+	stats := newStatsService()
+	w := make(chan bool, paraCount)
+	for i := 0; i < paraCount; i++ {
+		go func() {
+			stats.IncrementOversizedPackets()
+			w <- true
+		}()
+	}
+
+	for i := 0; i < paraCount; i++ {
+		<-w
+	}
+
+	if stats.OversizedPackets() != paraCount {
+		t.Error("wrong oversized packets count")
+	}
+
+	stats.Reset()
+	if stats.OversizedPackets() != 0 {
+		t.Error("oversized packets count not reset")
+	}
+}
+
+func TestStatsService_DroppedPackets(t *testing.T) {
+	stats := newStatsService()
+	stats.IncrementDroppedPackets()
+	stats.IncrementDroppedPackets()
+
+	if stats.DroppedPackets() != 2 {
+		t.Error("wrong dropped packets count")
+	}
+
+	stats.Reset()
+	if stats.DroppedPackets() != 0 {
+		t.Error("dropped packets count not reset")
+	}
+}
@@ -2,8 +2,10 @@ package session
 
 import (
 	"errors"
+	"net"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chrislonng/starx/log"
@@ -16,14 +18,16 @@ type NetworkEntity interface {
 	Push(session *Session, route string, v interface{}) error
 	Response(session *Session, v interface{}) error
 	Call(session *Session, route string, reply interface{}, args ...interface{}) error
+	Kick(session *Session, v interface{}) error
 	Close()
 }
 
 var (
-	ErrIllegalUID       = errors.New("illegal uid")
-	ErrKeyNotFound      = errors.New("current session does not contain key")
-	ErrWrongValueType   = errors.New("current key has different data type")
-	ErrReplyShouldBePtr = errors.New("reply should be a pointer")
+	ErrIllegalUID           = errors.New("illegal uid")
+	ErrKeyNotFound          = errors.New("current session does not contain key")
+	ErrWrongValueType       = errors.New("current key has different data type")
+	ErrReplyShouldBePtr     = errors.New("reply should be a pointer")
+	ErrReliableNotSupported = errors.New("network entity does not support reliable push")
 )
 
 // This session type as argument pass to Handler method, is a proxy session
@@ -35,12 +39,17 @@ var (
 type Session struct {
 	ID        int64                  // session global unique id
 	Uid       int64                  // binding user id
-	Entity    NetworkEntity          // raw session id, agent in frontend server, or acceptor in backend server
+	Entity    NetworkEntity          // raw session id, agent in frontend server, or acceptor in backend server, read it by NetworkEntity
 	LastID    uint                   // last request id
+	LastRoute string                 // last request route, route of response is not transferred
 	data      map[string]interface{} // session data store
 	lastTime  int64                  // last heartbeat time
 	serverIDs map[string]string      // map of server type -> server id
 
+	ClientInfo map[string]interface{} // client information reported in handshake
+
+	entityLock sync.RWMutex // protects Entity, which is replaced when session resumed
+
 	BelongToComponent interface{} //extend for easy find parentComponent
 }
 
@@ -52,9 +61,39 @@ func New(entity NetworkEntity) *Session {
 		data:      make(map[string]interface{}),
 		lastTime:  time.Now().Unix(),
 		serverIDs: make(map[string]string),
+
+		ClientInfo: make(map[string]interface{}),
 	}
 }
 
+// NetworkEntity returns the network entity of session, frontend session will
+// be attached to the agent of new connection when it has been resumed
+func (s *Session) NetworkEntity() NetworkEntity {
+	s.entityLock.RLock()
+	defer s.entityLock.RUnlock()
+
+	return s.Entity
+}
+
+// SetNetworkEntity replaces the network entity of session
+func (s *Session) SetNetworkEntity(e NetworkEntity) {
+	s.entityLock.Lock()
+	defer s.entityLock.Unlock()
+
+	s.Entity = e
+}
+
+// RemoteAddr returns the client address, which is the real client address
+// when proxy protocol enabled, nil will be returned in backend server
+func (s *Session) RemoteAddr() net.Addr {
+	if e, ok := s.NetworkEntity().(interface {
+		RemoteAddr() net.Addr
+	}); ok {
+		return e.RemoteAddr()
+	}
+	return nil
+}
+
 func (_this *Session) SetBelongToComponent(belonged interface{}) {
 	_this.BelongToComponent = belonged
 }
@@ -86,17 +125,30 @@ func (s *Session) SetServerID(svrType, svrID string) {
 
 // Session send packet data
 func (s *Session) Send(data []byte) error {
-	return s.Entity.Send(data)
+	return s.NetworkEntity().Send(data)
 }
 
 // Push message to session
 func (s *Session) Push(route string, v interface{}) error {
-	return s.Entity.Push(s, route, v)
+	return s.NetworkEntity().Push(s, route, v)
+}
+
+// PushReliable push message to session, the message will be retained until
+// client acks, and replayed in order after session resumed, it is optional for
+// NetworkEntity, ErrReliableNotSupported will be returned when not implemented
+func (s *Session) PushReliable(route string, v interface{}) error {
+	e, ok := s.NetworkEntity().(interface {
+		PushReliable(session *Session, route string, v interface{}) error
+	})
+	if !ok {
+		return ErrReliableNotSupported
+	}
+	return e.PushReliable(s, route, v)
 }
 
 // Response message to session
 func (s *Session) Response(v interface{}) error {
-	return s.Entity.Response(s, v)
+	return s.NetworkEntity().Response(s, v)
 }
 
 func (s *Session) Bind(uid int64) error {
@@ -105,6 +157,13 @@ func (s *Session) Bind(uid int64) error {
 		return ErrIllegalUID
 	}
 	s.Uid = uid
+
+	// notify the entity, e.g. frontend agent delivers offline messages
+	if e, ok := s.NetworkEntity().(interface {
+		Bound(s *Session)
+	}); ok {
+		e.Bound(s)
+	}
 	return nil
 }
 
@@ -112,11 +171,18 @@ func (s *Session) Call(route string, reply interface{}, args ...interface{}) err
 	if reflect.TypeOf(reply).Kind() != reflect.Ptr {
 		return ErrReplyShouldBePtr
 	}
-	return s.Entity.Call(s, route, reply, args...)
+	return s.NetworkEntity().Call(s, route, reply, args...)
 }
 
 func (s *Session) Close() {
-	s.Entity.Close()
+	s.NetworkEntity().Close()
+}
+
+// Kick send a kick packet with reason to client, and close the connection
+// after the packet has been flushed, client can tell a kick apart from a
+// network failure by the reason
+func (s *Session) Kick(reason interface{}) error {
+	return s.NetworkEntity().Kick(s, reason)
 }
 
 func (s *Session) Remove(key string) {
@@ -0,0 +1,184 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"net"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/log"
+)
+
+const (
+	defaultShutdownTimeout = 10 * time.Second
+	drainCheckInterval     = 10 * time.Millisecond
+)
+
+var (
+	shutdownOnce sync.Once
+	shuttingDown int32 // set when graceful shutdown started
+	inflight     int64 // handler and rpc calls in processing
+
+	// in-flight calls and their agents blocked in Shutdown, which are called
+	// by handlers and not waited by draining
+	waitingCalls  int64
+	waitingAgents int64
+
+	// handlers are called under these functions
+	handlerCallFunc = funcName((*handlerService).processMessage)
+	remoteCallFunc  = funcName((*remoteService).processRequest)
+
+	listenersLock sync.Mutex
+	listeners     []net.Listener // listeners of current server
+)
+
+// addListener tracks the listener, the listener will be closed when graceful
+// shutdown started, false will be returned when server is shutting down
+func addListener(l net.Listener) bool {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+
+	if isShuttingDown() {
+		return false
+	}
+	listeners = append(listeners, l)
+	return true
+}
+
+func closeListeners() {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	listeners = nil
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// beginCall and endCall wrap a handler or rpc call, shutdown waits for all
+// calls finished before components shutdown
+func beginCall() {
+	atomic.AddInt64(&inflight, 1)
+}
+
+func endCall() {
+	atomic.AddInt64(&inflight, -1)
+}
+
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+// callerFunc returns the function name of handler call which the caller is
+// under, empty string returned when it is not called by a handler
+func callerFunc() string {
+	pc := make([]uintptr, 128)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		frame, more := frames.Next()
+		if frame.Function == handlerCallFunc || frame.Function == remoteCallFunc {
+			return frame.Function
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// shutdown stops current server gracefully, it blocks until all steps
+// finished, the concurrent callers will be blocked as well:
+//  1. stop accepting connections
+//  2. kick all clients with shutdown message(frontend only)
+//  3. wait in-flight handler and rpc calls finished, up to shutdown timeout
+//  4. close all rpc clients
+//  5. shutdown all components
+func shutdown() {
+	stop(true)
+}
+
+// stop stops current server, clients will not be kicked immediately when kick
+// is false, e.g. graceful restart, the new process has taken over listeners,
+// existing clients are drained up to shutdown timeout before kicked
+func stop(kick bool) {
+	shutdownOnce.Do(func() {
+		log.Infof("server: " + app.config.Id + " is stopping...")
+
+		quiesce(kick, time.Now().Add(env.shutdownTimeout))
+
+		cluster.Close()
+
+		// shutdown all components registered by application, that
+		// call by reverse order against register
+		shutdownComps()
+
+		close(env.die)
+		log.Infof("server: " + app.config.Id + " has been stopped")
+	})
+}
+
+// quiesce stops accepting connections, and waits for all in-flight calls
+// finished and all clients disconnected before deadline
+func quiesce(kick bool, deadline time.Time) {
+	listenersLock.Lock()
+	atomic.StoreInt32(&shuttingDown, 1)
+	listenersLock.Unlock()
+	closeListeners()
+
+	if !app.config.IsFrontend {
+		drain(deadline)
+		return
+	}
+
+	if kick {
+		transporter.kickAll(env.shutdownMessage)
+	}
+	transporter.closeSuspended()
+
+	if !drain(deadline) && !kick {
+		transporter.kickAll(env.shutdownMessage)
+		drain(time.Now().Add(kickFlushTimeout))
+	}
+}
+
+// drain waits for all in-flight calls finished and all clients disconnected
+// before deadline, returns false when timeout
+func drain(deadline time.Time) bool {
+	for time.Now().Before(deadline) {
+		calls := atomic.LoadInt64(&inflight) - atomic.LoadInt64(&waitingCalls)
+		agents := int64(transporter.agentCount()) - atomic.LoadInt64(&waitingAgents)
+		if calls <= 0 && agents <= 0 {
+			return true
+		}
+		time.Sleep(drainCheckInterval)
+	}
+
+	log.Infof("Shutdown timeout, InFlight=%d, Agents=%d", atomic.LoadInt64(&inflight), transporter.agentCount())
+	return false
+}
@@ -0,0 +1,210 @@
+package starx
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chrislonng/starx/component"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/serialize/json"
+	"github.com/chrislonng/starx/session"
+)
+
+// quiesceAgent registers a pipe agent to transporter, and starts quiescing
+// server in background, done will be closed when quiesce returns
+func quiesceAgent(kick bool, timeout time.Duration) (a *agent, done chan struct{}) {
+	conn, _ := net.Pipe()
+	a = newAgent(conn)
+	transporter.Lock()
+	transporter.agents[a.id] = a
+	transporter.Unlock()
+
+	done = make(chan struct{})
+	go func() {
+		quiesce(kick, time.Now().Add(timeout))
+		close(done)
+	}()
+	return a, done
+}
+
+func resetShutdown(frontend bool, ts *transportService) {
+	app.config.IsFrontend = frontend
+	transporter = ts
+	atomic.StoreInt32(&shuttingDown, 0)
+}
+
+// resetStop allows the server to be stopped again
+func resetStop() {
+	shutdownOnce = sync.Once{}
+	env.die = make(chan bool)
+}
+
+type ShutdownComp struct {
+	component.Base
+}
+
+func (c *ShutdownComp) HandleShutdown(s *session.Session, m *JsonMessage) error {
+	Shutdown()
+	return nil
+}
+
+func TestShutdownWaitCall(t *testing.T) {
+	defer resetShutdown(app.config.IsFrontend, transporter)
+	defer resetStop()
+	transporter = newTransporter()
+
+	// Shutdown returns after the in-flight call finished
+	var finished int32
+	beginCall()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		endCall()
+	}()
+	Shutdown()
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("Shutdown returned before in-flight call finished")
+	}
+	select {
+	case <-env.die:
+	default:
+		t.Error("server should be stopped after Shutdown returned")
+	}
+}
+
+func TestShutdownInHandler(t *testing.T) {
+	defer resetShutdown(app.config.IsFrontend, transporter)
+	defer resetStop()
+	app.config.IsFrontend = true
+	transporter = newTransporter()
+	SetSerializer(json.NewSerializer())
+	handler.register(&ShutdownComp{})
+
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	transporter.agents[a.id] = a
+	data, err := serializeOrRaw(JsonMessage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &message.Message{Type: message.Notify, Route: "ShutdownComp.HandleShutdown", Data: data}
+
+	// the call of handler itself and its client are not waited
+	done := make(chan struct{})
+	go func() {
+		beginCall()
+		handler.processMessage(a.Session(), msg)
+		endCall()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown called in handler waits the handler itself")
+	}
+	select {
+	case <-env.die:
+	default:
+		t.Error("server should be stopped after Shutdown returned")
+	}
+	a.Close()
+}
+
+func TestQuiesceDrain(t *testing.T) {
+	defer resetShutdown(app.config.IsFrontend, transporter)
+	app.config.IsFrontend = true
+	transporter = newTransporter()
+
+	// clients are not kicked, and quiesce returns after all clients leave
+	a, done := quiesceAgent(false, time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if !isShuttingDown() {
+		t.Error("server should be shutting down")
+	}
+	if a.queueDepth() != 0 {
+		t.Fatal("client should not be kicked when draining")
+	}
+	select {
+	case <-done:
+		t.Fatal("quiesce returns before client disconnected")
+	default:
+	}
+	a.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("quiesce not returned after client disconnected")
+	}
+}
+
+func TestQuiesceDrainDeadline(t *testing.T) {
+	defer resetShutdown(app.config.IsFrontend, transporter)
+	app.config.IsFrontend = true
+	transporter = newTransporter()
+
+	// clients still connected after deadline are kicked
+	a, done := quiesceAgent(false, 50*time.Millisecond)
+	if waitQueued(a, time.Second) == nil {
+		t.Fatal("client should be kicked after deadline")
+	}
+	a.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("quiesce not returned after client kicked")
+	}
+}
+
+func TestQuiesceKick(t *testing.T) {
+	defer resetShutdown(app.config.IsFrontend, transporter)
+	app.config.IsFrontend = true
+	transporter = newTransporter()
+
+	// clients are kicked immediately, and quiesce returns after all
+	// clients leave
+	a, done := quiesceAgent(true, time.Minute)
+	data := waitQueued(a, time.Second)
+	if data == nil {
+		t.Fatal("client should be kicked immediately")
+	}
+	if !isKickPacket(data) {
+		t.Fatal("wrong kick packet")
+	}
+	a.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("quiesce not returned after client kicked")
+	}
+}
+
+func TestKickAllNonBlocking(t *testing.T) {
+	defer resetShutdown(app.config.IsFrontend, transporter)
+	transporter = newTransporter()
+
+	// client whose send queue is full of control packets is closed instead
+	// of blocking
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	transporter.agents[a.id] = a
+	for i := 0; i < a.queue.reserve; i++ {
+		a.queue.tryPush(nil, true)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		transporter.kickAll(env.shutdownMessage)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("kick all blocked by full send queue")
+	}
+	if a.status != statusClosed {
+		t.Error("client should be closed when kick packet can not be queued")
+	}
+}
@@ -40,3 +40,67 @@ func TestRegisterCount(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWheel(t *testing.T) {
+	w := NewWheel(10*time.Millisecond, 4)
+
+	var fired []int
+	for i, d := range []time.Duration{10, 30, 40, 50, 90} {
+		i := i
+		w.AfterFunc(d*time.Millisecond, func() {
+			fired = append(fired, i)
+		})
+	}
+	stopped := w.AfterFunc(20*time.Millisecond, func() {
+		t.Error("stopped timer fired")
+	})
+	if !stopped.Stop() {
+		t.Error("stop active timer failed")
+	}
+
+	reset := w.AfterFunc(10*time.Millisecond, func() {
+		fired = append(fired, 5)
+	})
+	reset.Reset(60 * time.Millisecond)
+
+	expects := [][]int{{0}, {0}, {0, 1}, {0, 1, 2}, {0, 1, 2, 3}, {0, 1, 2, 3, 5}, {0, 1, 2, 3, 5}, {0, 1, 2, 3, 5}, {0, 1, 2, 3, 5, 4}}
+	for tick, expect := range expects {
+		w.advance()
+		if len(fired) != len(expect) {
+			t.Fatalf("tick %d: expect %v, got %v", tick+1, expect, fired)
+		}
+		for i := range expect {
+			if fired[i] != expect[i] {
+				t.Fatalf("tick %d: expect %v, got %v", tick+1, expect, fired)
+			}
+		}
+	}
+
+	if reset.Stop() {
+		t.Error("stop expired timer should return false")
+	}
+}
+
+const benchSessions = 100000
+
+// BenchmarkWheel_100kSessions advances a wheel tracking 100k session
+// heartbeat timers for a heartbeat interval(10 ticks), each timer is reset
+// when expired
+func BenchmarkWheel_100kSessions(b *testing.B) {
+	const interval = time.Second
+	w := NewWheel(interval/10, 64)
+	for i := 0; i < benchSessions; i++ {
+		var t *WheelTimer
+		t = w.AfterFunc(time.Duration(i%10+1)*interval/10, func() {
+			t.Reset(interval)
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10; j++ {
+			w.advance()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// Wheel is a hashed timing wheel, the timers are hashed into slots by the
+// expiration, only timers in the current slot are checked on every tick, so
+// it is cheap to track a large number of timers(e.g. heartbeat of sessions).
+// The resolution of timers is the tick duration, and the callbacks are called
+// in the wheel goroutine, so they should not be blocked.
+type Wheel struct {
+	sync.Mutex
+	tick    time.Duration
+	slots   []WheelTimer // sentinels of timer lists
+	pos     int          // current slot
+	expired []func()     // callbacks of expired timers, reused by advance
+	end     chan bool
+}
+
+// WheelTimer represents a timer in the wheel, timers in the same slot are
+// linked as a circular list, so no allocation on reset
+type WheelTimer struct {
+	wheel      *Wheel
+	fn         func()
+	rounds     int // remaining revolutions before expired
+	prev, next *WheelTimer
+}
+
+// NewWheel returns a wheel with slots, the wheel advances a slot per tick
+// after started
+func NewWheel(tick time.Duration, slots int) *Wheel {
+	if tick <= 0 || slots <= 0 {
+		panic("timer: non-positive tick or slots for NewWheel")
+	}
+
+	w := &Wheel{
+		tick:  tick,
+		slots: make([]WheelTimer, slots),
+		end:   make(chan bool, 1),
+	}
+	for i := range w.slots {
+		w.slots[i].prev = &w.slots[i]
+		w.slots[i].next = &w.slots[i]
+	}
+	return w
+}
+
+// Start advances the wheel in a new goroutine
+func (w *Wheel) Start() {
+	ticker := time.NewTicker(w.tick)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				w.advance()
+			case <-w.end:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (w *Wheel) Stop() {
+	w.end <- true
+}
+
+// NewTimer returns an inactive timer, which calls fn in wheel goroutine
+// after activated by Reset
+func (w *Wheel) NewTimer(fn func()) *WheelTimer {
+	return &WheelTimer{wheel: w, fn: fn}
+}
+
+// AfterFunc calls fn in wheel goroutine after duration d
+func (w *Wheel) AfterFunc(d time.Duration, fn func()) *WheelTimer {
+	t := w.NewTimer(fn)
+	t.Reset(d)
+	return t
+}
+
+func (w *Wheel) add(t *WheelTimer, d time.Duration) {
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	t.rounds = (ticks - 1) / len(w.slots)
+
+	// push back to the slot list
+	head := &w.slots[(w.pos+ticks)%len(w.slots)]
+	t.prev = head.prev
+	t.next = head
+	head.prev.next = t
+	head.prev = t
+}
+
+func (w *Wheel) remove(t *WheelTimer) bool {
+	if t.next == nil {
+		return false
+	}
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev = nil
+	t.next = nil
+	return true
+}
+
+// advance moves to next slot, and calls the callbacks of expired timers
+// outside the lock, so callbacks could reset timers. It should only be
+// called in a single goroutine.
+func (w *Wheel) advance() {
+	w.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+
+	expired := w.expired[:0]
+	head := &w.slots[w.pos]
+	for t := head.next; t != head; {
+		next := t.next
+		if t.rounds > 0 {
+			t.rounds--
+		} else {
+			w.remove(t)
+			expired = append(expired, t.fn)
+		}
+		t = next
+	}
+	w.Unlock()
+
+	for i, fn := range expired {
+		fn()
+		expired[i] = nil
+	}
+	w.expired = expired[:0]
+}
+
+// Reset changes the timer to expire after duration d, it returns true if
+// the timer had been active
+func (t *WheelTimer) Reset(d time.Duration) bool {
+	t.wheel.Lock()
+	defer t.wheel.Unlock()
+
+	active := t.wheel.remove(t)
+	t.wheel.add(t, d)
+	return active
+}
+
+// Stop prevents the timer from firing, it returns true if the timer had
+// been active
+func (t *WheelTimer) Stop() bool {
+	t.wheel.Lock()
+	defer t.wheel.Unlock()
+
+	return t.wheel.remove(t)
+}
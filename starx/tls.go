@@ -0,0 +1,161 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/log"
+)
+
+var (
+	ErrInvalidClientCA = errors.New("no valid certificate found in client ca file")
+	ErrNoCertificate   = errors.New("listener requires TLS without certificate")
+)
+
+// certReloader holds the tls config loaded from certificate files, the files
+// will be loaded again when the process receives SIGHUP, new connections will
+// use the new certificates, established connections are not affected
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	config   atomic.Value // *tls.Config
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads certificate files, the old config will be kept when failed
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	// verify client certificate when client ca specified
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return ErrInvalidClientCA
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config.Store(config)
+	return nil
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return r.config.Load().(*tls.Config), nil
+}
+
+var (
+	reloadersLock sync.Mutex
+	reloaders     map[string]*certReloader // key is certificate files
+)
+
+// certReloaderOf returns the reloader of the certificate files, listeners with
+// the same certificates share a reloader, and all reloaders are reloaded by a
+// single SIGHUP watcher
+func certReloaderOf(certFile, keyFile, caFile string) (*certReloader, error) {
+	reloadersLock.Lock()
+	defer reloadersLock.Unlock()
+
+	key := certFile + "\x00" + keyFile + "\x00" + caFile
+	if r, ok := reloaders[key]; ok {
+		return r, nil
+	}
+
+	r, err := newCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if reloaders == nil {
+		reloaders = make(map[string]*certReloader)
+
+		// signal should be subscribed before config returned, otherwise
+		// SIGHUP received before watching will terminate the process
+		sg := make(chan os.Signal, 1)
+		signal.Notify(sg, syscall.SIGHUP)
+		go watchCerts(sg)
+	}
+	reloaders[key] = r
+	return r, nil
+}
+
+// watchCerts reloads all certificates when SIGHUP received from sg
+func watchCerts(sg chan os.Signal) {
+	for range sg {
+		reloadersLock.Lock()
+		for _, r := range reloaders {
+			if err := r.reload(); err != nil {
+				log.Errorf("Reload certificates failed, CertFile=%s, Error=%s", r.certFile, err.Error())
+				continue
+			}
+			log.Infof("Certificates reloaded, CertFile=%s", r.certFile)
+		}
+		reloadersLock.Unlock()
+	}
+}
+
+// tlsConfig returns the tls config of the listener, nil will be returned when
+// the listener serves plain connections
+func tlsConfig(l *cluster.ListenerConfig) (*tls.Config, error) {
+	if l.CertFile == "" {
+		if l.TLS {
+			return nil, ErrNoCertificate
+		}
+		return nil, nil
+	}
+
+	r, err := certReloaderOf(l.CertFile, l.KeyFile, l.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetConfigForClient: r.getConfigForClient}, nil
+}
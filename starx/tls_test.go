@@ -0,0 +1,220 @@
+//go:build !windows
+// +build !windows
+
+package starx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/transport"
+)
+
+// writeCert writes a self-signed certificate with the serial number and its
+// private key to the files
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "starx"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS listens on memory transport with the listener config, and returns
+// the address, connections will be closed after client closed
+func serveTLS(t *testing.T, l *cluster.ListenerConfig) (net.Listener, string) {
+	config, err := tlsConfig(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := transport.Memory.Listen(l.Address(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// read until client closed, close notify is written by
+			// client only, both sides are blocked otherwise
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener, l.Address()
+}
+
+// peerSerial handshakes with the server, and returns the serial number of
+// server certificate
+func peerSerial(addr string, cert *tls.Certificate) (int64, error) {
+	conn, err := transport.Memory.Dial(addr)
+	if err != nil {
+		return 0, err
+	}
+	// TLS 1.2 rejects client certificate in handshake, and nothing will be
+	// written by server after handshake through the synchronous pipe
+	config := &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	c := tls.Client(conn, config)
+	defer c.Close()
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestListenerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starx-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1)
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	writeCert(t, caFile, caKeyFile, 2)
+
+	// certificate is a per-listener setting, server certificate is the
+	// default of listeners requiring TLS, plain listener is served together
+	c := &cluster.ServerConfig{
+		Host:     "tls",
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Listeners: []*cluster.ListenerConfig{
+			{Protocol: cluster.ProtocolMemory, Port: 1, TLS: true},
+			{Protocol: cluster.ProtocolMemory, Port: 2, CertFile: caFile, KeyFile: caKeyFile, ClientCA: caFile},
+			{Protocol: cluster.ProtocolMemory, Port: 3},
+		},
+	}
+	listeners := c.AllListeners()
+	if listeners[0].CertFile != certFile || listeners[1].CertFile != caFile || listeners[2].CertFile != "" {
+		t.Fatalf("wrong listener certificates: %+v, %+v, %+v", listeners[0], listeners[1], listeners[2])
+	}
+	if config, err := tlsConfig(listeners[2]); config != nil || err != nil {
+		t.Fatal("listener without certificate should not serve TLS")
+	}
+	if _, err := tlsConfig(&cluster.ListenerConfig{Protocol: cluster.ProtocolMemory, TLS: true}); err != ErrNoCertificate {
+		t.Fatalf("expect %v, got %v", ErrNoCertificate, err)
+	}
+
+	// listeners with the same certificates share a reloader
+	r1, err := certReloaderOf(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2, err := certReloaderOf(certFile, keyFile, ""); err != nil || r2 != r1 {
+		t.Fatal("reloader should be shared by the same certificates")
+	}
+
+	l1, addr1 := serveTLS(t, listeners[0])
+	defer l1.Close()
+	l2, addr2 := serveTLS(t, listeners[1])
+	defer l2.Close()
+
+	if serial, err := peerSerial(addr1, nil); err != nil || serial != 1 {
+		t.Fatalf("expect certificate 1, got %d %v", serial, err)
+	}
+
+	// client certificate is required by the listener with client ca
+	if _, err := peerSerial(addr2, nil); err == nil {
+		t.Fatal("client without certificate should be rejected")
+	}
+	cert, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial, err := peerSerial(addr2, &cert); err != nil || serial != 2 {
+		t.Fatalf("expect certificate 2, got %d %v", serial, err)
+	}
+}
+
+func TestListenerTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "starx-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1)
+
+	l, addr := serveTLS(t, &cluster.ListenerConfig{
+		Protocol: cluster.ProtocolMemory,
+		Host:     "tls-reload",
+		Port:     1,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	defer l.Close()
+	if serial, err := peerSerial(addr, nil); err != nil || serial != 1 {
+		t.Fatalf("expect certificate 1, got %d %v", serial, err)
+	}
+
+	// new connections use the new certificate after SIGHUP
+	writeCert(t, certFile, keyFile, 2)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		serial, err := peerSerial(addr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
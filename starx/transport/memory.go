@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	ErrAddrInUse   = errors.New("address already in use")
+	ErrConnRefused = errors.New("connection refused")
+)
+
+const pipeNetwork = "pipe"
+
+// memory is the in-process transport based on net.Pipe, the address is an
+// arbitrary name, it lets servers connect each other without opening ports,
+// e.g. a gate and its backends served in one test process, clients dial the
+// gate and requests are forwarded to backends in process
+type memory struct {
+	sync.Mutex
+	listeners map[string]*pipeListener
+}
+
+func (m *memory) Name() string {
+	return "memory"
+}
+
+func (m *memory) Listen(addr string, config *tls.Config) (net.Listener, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.listeners[addr]; ok {
+		return nil, ErrAddrInUse
+	}
+
+	l := &pipeListener{
+		transport: m,
+		addr:      pipeAddr(addr),
+		conns:     make(chan net.Conn),
+		die:       make(chan struct{}),
+	}
+	m.listeners[addr] = l
+
+	if config != nil {
+		return tls.NewListener(l, config), nil
+	}
+	return l, nil
+}
+
+func (m *memory) Dial(addr string) (net.Conn, error) {
+	m.Lock()
+	l, ok := m.listeners[addr]
+	m.Unlock()
+
+	if !ok {
+		return nil, ErrConnRefused
+	}
+
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.die:
+		server.Close()
+		client.Close()
+		return nil, ErrConnRefused
+	}
+}
+
+func (m *memory) remove(l *pipeListener) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.listeners[string(l.addr)] == l {
+		delete(m.listeners, string(l.addr))
+	}
+}
+
+// pipeListener accepts connections dialed by memory transport
+type pipeListener struct {
+	transport *memory
+	addr      pipeAddr
+	conns     chan net.Conn
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.die:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.die)
+		l.transport.remove(l)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+// pipeAddr is the address of memory listener
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return pipeNetwork
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
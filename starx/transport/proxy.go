@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoProxyHeader      = errors.New("proxy protocol header not found")
+	ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+const (
+	// Max duration waiting for the proxy protocol header
+	proxyHeaderTimeout = 5 * time.Second
+
+	// Max length of proxy protocol v1 header, includes CRLF
+	proxyV1MaxLength = 107
+
+	proxyV2HeadLength = 16
+)
+
+// Signature of proxy protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// NewProxyListener returns a listener which accepts connections behind a
+// load balancer, the HAProxy PROXY protocol(v1 or v2) header will be parsed
+// before the first read, and RemoteAddr returns the real client address
+func NewProxyListener(l net.Listener) net.Listener {
+	return &proxyListener{Listener: l}
+}
+
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept does not wait for the header, avoid blocking accepting loop
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReaderSize(conn, 256)}, nil
+}
+
+// proxyConn parses the proxy protocol header lazily, both Read and
+// RemoteAddr will be blocked until the header parsed
+type proxyConn struct {
+	net.Conn
+	r        *bufio.Reader
+	once     sync.Once
+	src      net.Addr  // real client address, nil for LOCAL/UNKNOWN command
+	err      error     // error occurred when parsing header
+	deadline time.Time // read deadline set by user, restored after header parsed
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.src, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(c.deadline)
+	})
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// WriteBuffers writes buffers to the wrapped connection, vectored write of the
+// wrapped connection is hidden by proxyConn otherwise
+func (c *proxyConn) WriteBuffers(bufs *net.Buffers) (int64, error) {
+	return bufs.WriteTo(c.Conn)
+}
+
+// RemoteAddr returns the client address in proxy protocol header, the address
+// of peer will be returned when header does not contain client address
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads proxy protocol v1 or v2 header, returns the source
+// address in header
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case 'P':
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		return readProxyV2(r)
+	default:
+		return nil, ErrNoProxyHeader
+	}
+}
+
+// readProxyV1 parses the human readable header, e.g.
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, ErrInvalidProxyHeader
+		}
+		ip := net.ParseIP(fields[2])
+		port, err := strconv.Atoi(fields[4])
+		if ip == nil || err != nil || port < 0 || port > 65535 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: ip, Port: port}, nil
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+}
+
+// readProxyV2 parses the binary header, TLVs will be discarded
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, proxyV2HeadLength)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(head[:len(proxyV2Signature)], proxyV2Signature) || head[12]>>4 != 0x2 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command, connection established by proxy itself
+	if head[12]&0xF == 0x0 {
+		return nil, nil
+	}
+	if head[12]&0xF != 0x1 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// address family and transport protocol
+	switch head[13] {
+	case 0x11, 0x12: // TCP/UDP over IPv4
+		if len(body) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case 0x21, 0x22: // TCP/UDP over IPv6
+		if len(body) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	default:
+		// unspecified or unix socket, keep the peer address
+		return nil, nil
+	}
+}
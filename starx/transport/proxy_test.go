@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func proxyV2Header(cmd, fam byte, addr []byte) []byte {
+	head := append([]byte{}, proxyV2Signature...)
+	head = append(head, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(head[14:], uint16(len(addr)))
+	return append(head, addr...)
+}
+
+func TestProxyListener(t *testing.T) {
+	ipv4 := []byte{10, 0, 0, 1, 10, 0, 0, 2, 0x30, 0x39, 0x01, 0xbb}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(ipv6[32:], 12345)
+
+	tests := []struct {
+		name   string
+		header []byte
+		addr   string // empty means peer address
+		err    error
+	}{
+		{"v1-tcp4", []byte("PROXY TCP4 10.0.0.1 10.0.0.2 12345 443\r\n"), "10.0.0.1:12345", nil},
+		{"v1-tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"), "[2001:db8::1]:12345", nil},
+		{"v1-unknown", []byte("PROXY UNKNOWN\r\n"), "", nil},
+		{"v2-ipv4", proxyV2Header(0x1, 0x11, ipv4), "10.0.0.1:12345", nil},
+		{"v2-ipv6", proxyV2Header(0x1, 0x21, ipv6), "[2001:db8::1]:12345", nil},
+		{"v2-local", proxyV2Header(0x0, 0x00, nil), "", nil},
+		{"v1-invalid", []byte("PROXY TCP4 10.0.0.1\r\n"), "", ErrInvalidProxyHeader},
+		{"v2-truncated", proxyV2Header(0x1, 0x11, ipv4[:8]), "", ErrInvalidProxyHeader},
+		{"missing", []byte{0x01, 0x00, 0x00, 0x00}, "", ErrNoProxyHeader},
+	}
+
+	for _, tt := range tests {
+		l, err := TCP.Listen("127.0.0.1:0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pl := NewProxyListener(l)
+
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write(append(tt.header, "starx"...))
+
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		if err != tt.err {
+			t.Fatalf("%s: expect error %v, got %v", tt.name, tt.err, err)
+		}
+		if err == nil && string(buf) != "starx" {
+			t.Fatalf("%s: expect starx, got %s", tt.name, buf)
+		}
+
+		addr := tt.addr
+		if addr == "" {
+			addr = c.LocalAddr().String()
+		}
+		if tt.err == nil && conn.RemoteAddr().String() != addr {
+			t.Fatalf("%s: expect %s, got %s", tt.name, addr, conn.RemoteAddr())
+		}
+
+		c.Close()
+		conn.Close()
+		pl.Close()
+	}
+}
+
+func TestProxyConnWriteBuffers(t *testing.T) {
+	l, err := TCP.Listen("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := NewProxyListener(l)
+	defer pl.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// buffers are written to the wrapped connection directly
+	bw, ok := conn.(BuffersWriter)
+	if !ok {
+		t.Fatal("proxy connection should write buffers to the wrapped connection")
+	}
+	bufs := net.Buffers{[]byte("hello "), []byte("world")}
+	if n, err := bw.WriteBuffers(&bufs); err != nil || n != 11 {
+		t.Fatalf("write buffers: %d %v", n, err)
+	}
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello world" {
+		t.Fatalf("expect hello world, got %q %v", buf, err)
+	}
+}
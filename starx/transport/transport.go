@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownTransport = errors.New("unknown transport")
+	ErrListenerClosed   = errors.New("listener closed")
+)
+
+// Transport represents a kind of network transport, which is used by
+// frontend servers to accept client connections and by backend servers to
+// accept rpc connections from frontend servers
+type Transport interface {
+	// Name returns the transport name, which is used as the protocol of
+	// listener config
+	Name() string
+
+	// Listen announces on the address, the listener should serve TLS when
+	// the tls config is not nil
+	Listen(addr string, config *tls.Config) (net.Listener, error)
+
+	// Dial connects to the address which a listener of the transport
+	// announced on
+	Dial(addr string) (net.Conn, error)
+}
+
+// Inheritable is implemented by the transports based on file descriptor, the
+// raw listener could be passed to a new process on graceful restart, and the
+// new process serves the transport on the inherited raw listener
+type Inheritable interface {
+	Transport
+
+	// Announce returns the raw listener on the address
+	Announce(addr string) (net.Listener, error)
+
+	// Serve returns the transport listener based on the raw listener, the
+	// listener should serve TLS when the tls config is not nil
+	Serve(raw net.Listener, addr string, config *tls.Config) (net.Listener, error)
+}
+
+// BuffersWriter is implemented by connections wrapping another connection,
+// buffers are written with vectored write of the wrapped connection when it
+// supports
+type BuffersWriter interface {
+	WriteBuffers(bufs *net.Buffers) (int64, error)
+}
+
+var (
+	mu         sync.RWMutex
+	transports = make(map[string]Transport)
+)
+
+var (
+	TCP    Transport = &stream{network: "tcp"}
+	Unix   Transport = &stream{network: "unix"}
+	WS               = &WebSocket{}
+	Memory Transport = &memory{listeners: make(map[string]*pipeListener)}
+)
+
+func init() {
+	Register(TCP)
+	Register(Unix)
+	Register(WS)
+	Register(Memory)
+}
+
+// Register a transport, the transport with the same name will be replaced
+func Register(t Transport) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	transports[t.Name()] = t
+}
+
+// Lookup returns the transport registered with name
+func Lookup(name string) (Transport, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	t, ok := transports[strings.TrimSpace(name)]
+	if !ok {
+		return nil, ErrUnknownTransport
+	}
+	return t, nil
+}
+
+// stream is the transport based on net package, e.g. tcp and unix
+type stream struct {
+	network string
+}
+
+func (s *stream) Name() string {
+	return s.network
+}
+
+func (s *stream) Listen(addr string, config *tls.Config) (net.Listener, error) {
+	raw, err := s.Announce(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.Serve(raw, addr, config)
+}
+
+func (s *stream) Announce(addr string) (net.Listener, error) {
+	return net.Listen(s.network, addr)
+}
+
+func (s *stream) Serve(raw net.Listener, addr string, config *tls.Config) (net.Listener, error) {
+	if config != nil {
+		return tls.NewListener(raw, config), nil
+	}
+	return raw, nil
+}
+
+func (s *stream) Dial(addr string) (net.Conn, error) {
+	return net.Dial(s.network, addr)
+}
@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func echo(t *testing.T, tr Transport, addr string) {
+	l, err := tr.Listen(addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	dialAddr := addr
+	if tr == TCP || tr == WS {
+		// random port allocated by listener
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		host, path := splitAddr(addr)
+		h, _, _ := net.SplitHostPort(host)
+		dialAddr = net.JoinHostPort(h, port)
+		if tr == WS {
+			dialAddr += path
+		}
+	}
+
+	conn, err := tr.Dial(dialAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"hello", "starx"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != msg {
+			t.Fatalf("%s: expect %s, got %s", tr.Name(), msg, buf)
+		}
+	}
+}
+
+func TestTransports(t *testing.T) {
+	echo(t, TCP, "127.0.0.1:0")
+	echo(t, Unix, filepath.Join(t.TempDir(), "starx.sock"))
+	echo(t, WS, "127.0.0.1:0/ws")
+	echo(t, Memory, "gate-server-1")
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"tcp", "ws", "unix", "memory"} {
+		tr, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.Name() != name {
+			t.Fatalf("expect %s, got %s", name, tr.Name())
+		}
+	}
+
+	if _, err := Lookup("quic"); err != ErrUnknownTransport {
+		t.Fatalf("expect ErrUnknownTransport, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	if _, err := Memory.Dial("not-exists"); err != ErrConnRefused {
+		t.Fatalf("expect ErrConnRefused, got %v", err)
+	}
+
+	l, err := Memory.Listen("backend-server-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Memory.Listen("backend-server-1", nil); err != ErrAddrInUse {
+		t.Fatalf("expect ErrAddrInUse, got %v", err)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err != ErrListenerClosed {
+		t.Fatalf("expect ErrListenerClosed, got %v", err)
+	}
+	if _, err := Memory.Dial("backend-server-1"); err != ErrConnRefused {
+		t.Fatalf("expect ErrConnRefused, got %v", err)
+	}
+}
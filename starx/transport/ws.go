@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket is the transport based on websocket, the address is composed of
+// host, port and an optional path, e.g. 127.0.0.1:3250/ws
+type WebSocket struct {
+	// CheckOrigin returns true if the request Origin header is acceptable,
+	// refs websocket.Upgrader
+	CheckOrigin func(*http.Request) bool
+}
+
+func (ws *WebSocket) Name() string {
+	return "ws"
+}
+
+// splitAddr splits websocket address into host:port and path
+func splitAddr(addr string) (string, string) {
+	if i := strings.Index(addr, "/"); i >= 0 {
+		return addr[:i], addr[i:]
+	}
+	return addr, "/"
+}
+
+func (ws *WebSocket) Listen(addr string, config *tls.Config) (net.Listener, error) {
+	raw, err := ws.Announce(addr)
+	if err != nil {
+		return nil, err
+	}
+	return ws.Serve(raw, addr, config)
+}
+
+func (ws *WebSocket) Announce(addr string) (net.Listener, error) {
+	hostport, _ := splitAddr(addr)
+	return net.Listen("tcp", hostport)
+}
+
+func (ws *WebSocket) Serve(raw net.Listener, addr string, config *tls.Config) (net.Listener, error) {
+	_, path := splitAddr(addr)
+	l := raw
+	if config != nil {
+		l = tls.NewListener(raw, config)
+	}
+
+	wl := &wsListener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		die:      make(chan struct{}),
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     ws.CheckOrigin,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		select {
+		case wl.conns <- NewConn(conn):
+		case <-wl.die:
+			conn.Close()
+		}
+	})
+
+	go func() {
+		http.Serve(l, mux)
+		wl.Close()
+	}()
+
+	return wl, nil
+}
+
+func (ws *WebSocket) Dial(addr string) (net.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+// wsListener accepts upgraded websocket connections
+type wsListener struct {
+	net.Listener
+	conns     chan net.Conn
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.die:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.die)
+		err = l.Listener.Close()
+	})
+	return err
+}
+
+// wsConn is an adapter to net.Conn, which implements all net.Conn
+// interface base on *websocket.Conn
+type wsConn struct {
+	conn   *websocket.Conn
+	reader io.Reader // reader of current message
+}
+
+// NewConn returns a net.Conn based on *websocket.Conn, the message reader
+// will be fetched on the first read
+func NewConn(conn *websocket.Conn) net.Conn {
+	return &wsConn{conn: conn}
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetReadDeadline.
+func (c *wsConn) Read(b []byte) (int, error) {
+	if c.reader == nil {
+		_, r, err := c.conn.NextReader()
+		if err != nil {
+			return 0, err
+		}
+		c.reader = r
+	}
+
+	n, err := c.reader.Read(b)
+	if err == io.EOF {
+		// current message has been read, continue with next message
+		c.reader = nil
+		return n, nil
+	}
+
+	return n, err
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetWriteDeadline.
+func (c *wsConn) Write(b []byte) (int, error) {
+	err := c.conn.WriteMessage(websocket.BinaryMessage, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Close closes the connection.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local network address.
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines associated
+// with the connection. It is equivalent to calling both
+// SetReadDeadline and SetWriteDeadline.
+//
+// A deadline is an absolute time after which I/O operations
+// fail with a timeout (see type Error) instead of
+// blocking. The deadline applies to all future and pending
+// I/O, not just the immediately following call to Read or
+// Write. After a deadline has been exceeded, the connection
+// can be refreshed by setting a deadline in the future.
+//
+// An idle timeout can be implemented by repeatedly extending
+// the deadline after successful Read or Write calls.
+//
+// A zero value for t means I/O operations will not time out.
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls
+// and any currently-blocked Read call.
+// A zero value for t means Read will not time out.
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for future Write calls
+// and any currently-blocked Write call.
+// Even if write times out, it may return n > 0, indicating that
+// some of the data was successfully written.
+// A zero value for t means Write will not time out.
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
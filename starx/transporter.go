@@ -23,15 +23,19 @@ package starx
 import (
 	"errors"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/chrislonng/starx/cluster"
+	"github.com/chrislonng/starx/codec"
 	"github.com/chrislonng/starx/log"
 	"github.com/chrislonng/starx/message"
 	"github.com/chrislonng/starx/packet"
+	"github.com/chrislonng/starx/service"
 	"github.com/chrislonng/starx/session"
+	"github.com/chrislonng/starx/timer"
 )
 
 const sessionClosedRoute = "__Session.Closed"
@@ -39,11 +43,10 @@ const sessionClosedRoute = "__Session.Closed"
 var (
 	ErrSessionOnNotify = errors.New("current session working on notify mode")
 	ErrSessionNotFound = errors.New("session not found")
+	ErrMessageTooLarge = errors.New("message too large")
 )
 
 var (
-	heartbeatPacket, _ = packet.Pack(&packet.Packet{Type: packet.Heartbeat})
-
 	// transporter represents a manager, which manages low-level transport
 	// layer object, that abstract as `agent` in frontend server or `acceptor`
 	// in the backend server
@@ -52,9 +55,13 @@ var (
 
 type transportService struct {
 	sync.RWMutex
-	agents      map[int64]*agent    // agents map
-	acceptorUid int64               // acceptor unique id
-	acceptors   map[int64]*acceptor // acceptor map
+	agents      map[int64]*agent             // agents map
+	wheel       *timer.Wheel                 // heartbeat timing wheel, nil when heartbeat disabled
+	suspended   map[string]*suspendedSession // sessions waiting for resuming, key is resume token
+	outboxes    map[int64]*outbox            // reliable push outbox, key is session id
+	uids        map[int64]*session.Session   // online sessions, key is bound uid
+	acceptorUid int64                        // acceptor unique id
+	acceptors   map[int64]*acceptor          // acceptor map
 
 	sessionCloseCbLock sync.RWMutex             // protect sessionCloseCb
 	sessionCloseCb     []func(*session.Session) // callback on session closed
@@ -64,20 +71,49 @@ type transportService struct {
 func newTransporter() *transportService {
 	return &transportService{
 		agents:      make(map[int64]*agent),
+		suspended:   make(map[string]*suspendedSession),
+		outboxes:    make(map[int64]*outbox),
+		uids:        make(map[int64]*session.Session),
 		acceptorUid: 0,
 		acceptors:   make(map[int64]*acceptor),
 	}
 }
 
-// Create agent via transportService
-func (t *transportService) createAgent(conn net.Conn) *agent {
+// Create agent via transportService, error will be returned when the
+// connection is rejected by admission control
+func (t *transportService) createAgent(conn net.Conn) (*agent, error) {
+	ip := remoteIP(conn.RemoteAddr())
+	if err := service.Connections.Acquire(ip); err != nil {
+		return nil, err
+	}
+
 	a := newAgent(conn)
+	a.ip = ip
+
+	if t.wheel != nil {
+		a.heartbeatTimer = t.wheel.NewTimer(func() {
+			t.heartbeat(a)
+		})
+		a.heartbeatTimer.Reset(env.heartbeatInternal)
+	}
+
 	// add to maps
 	t.Lock()
 	defer t.Unlock()
 
 	t.agents[a.id] = a
-	return a
+	t.outboxes[a.id] = &outbox{}
+	return a, nil
+}
+
+// remoteIP returns the ip of remote address, the whole address will be
+// returned when it does not contain port, e.g. pipe or unix socket
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
 }
 
 // get agent by session id
@@ -87,7 +123,7 @@ func (t *transportService) agent(id int64) (*agent, error) {
 
 	a, ok := t.agents[id]
 	if !ok {
-		return nil, errors.New("agent id: " + string(id) + " not exists!")
+		return nil, errors.New("agent id: " + strconv.FormatInt(id, 10) + " not exists!")
 	}
 
 	return a, nil
@@ -112,7 +148,7 @@ func (t *transportService) acceptor(id int64) (*acceptor, error) {
 
 	rs, ok := t.acceptors[id]
 	if !ok || rs == nil {
-		return nil, errors.New("acceptor id: " + string(id) + " not exists!")
+		return nil, errors.New("acceptor id: " + strconv.FormatInt(id, 10) + " not exists!")
 	}
 
 	return rs, nil
@@ -121,37 +157,23 @@ func (t *transportService) acceptor(id int64) (*acceptor, error) {
 // Send packet data, call by package internal, the second argument was packaged packet
 // if current server is frontend server, send to client by agent, else send to frontend
 // server by acceptor
-func (t *transportService) send(session *session.Session, data []byte) {
-	session.Entity.Send(data)
+func (t *transportService) send(session *session.Session, data []byte) error {
+	return session.NetworkEntity().Send(data)
 }
 
 // Push message to client
 // call by all package, the last argument was packaged message
 func (t *transportService) push(session *session.Session, route string, data []byte) error {
-	m, err := message.Encode(&message.Message{
+	ep, err := packMessage(session, &message.Message{
 		Type:  message.MessageType(message.Push),
 		Route: route,
 		Data:  data,
 	})
-
-	if err != nil {
-		log.Errorf(err.Error())
-		return err
-	}
-
-	p := packet.Packet{
-		Type:   packet.Data,
-		Length: len(m),
-		Data:   m,
-	}
-	ep, err := p.Pack()
 	if err != nil {
-		log.Errorf(err.Error())
 		return err
 	}
 
-	t.send(session, ep)
-	return nil
+	return t.send(session, ep)
 }
 
 // Response message to client
@@ -161,29 +183,120 @@ func (t *transportService) response(session *session.Session, data []byte) error
 	if session.LastID <= 0 {
 		return ErrSessionOnNotify
 	}
-	m, err := message.Encode(&message.Message{
+	ep, err := packMessage(session, &message.Message{
 		Type: message.MessageType(message.Response),
 		ID:   session.LastID,
 		Data: data,
 	})
+	if err != nil {
+		return err
+	}
+
+	return t.send(session, ep)
+}
+
+// Kick client, the connection will be closed after the kick packet flushed
+// call by all package, the last argument was serialized reason
+func (t *transportService) kick(session *session.Session, data []byte) error {
+	ep, err := codecOf(session).Pack(&packet.Packet{Type: packet.Kick, Data: data})
 	if err != nil {
 		log.Errorf(err.Error())
 		return err
 	}
 
-	p := packet.Packet{
-		Type:   packet.Data,
-		Length: len(m),
-		Data:   m,
+	return session.NetworkEntity().Send(ep)
+}
+
+// kickAll kicks all clients without blocking, the connections will be closed
+// after the kick packets flushed, or closed immediately when the kick packet
+// can not be queued, e.g. the send queue is full of control packets
+func (t *transportService) kickAll(v interface{}) {
+	data, err := serializeOrRaw(v)
+	if err != nil {
+		data = nil
+	}
+
+	t.RLock()
+	agents := make([]*agent, 0, len(t.agents))
+	for _, a := range t.agents {
+		agents = append(agents, a)
+	}
+	t.RUnlock()
+
+	for _, a := range agents {
+		p, err := a.codec().Pack(&packet.Packet{Type: packet.Kick, Data: data})
+		if err != nil || !a.trySend(p) {
+			log.Infof("Kick session failed, session will be closed, %s", a.String())
+			a.Close()
+		}
+	}
+}
+
+func (t *transportService) agentCount() int {
+	t.RLock()
+	defer t.RUnlock()
+
+	return len(t.agents)
+}
+
+// protocolOf returns the wire protocol which session negotiated in handshake,
+// backend session always use the default codec without compression
+func protocolOf(session *session.Session) wireProtocol {
+	if a, ok := session.NetworkEntity().(*agent); ok {
+		return a.protocol()
+	}
+	return wireProtocol{codec: codec.Default}
+}
+
+func codecOf(session *session.Session) codec.Codec {
+	return protocolOf(session).codec
+}
+
+// packMessage encode message and pack it as a data packet with the session's
+// codec, data will be deflated when client supports and route not disabled,
+// response follows the setting of request route, message larger than max
+// message size will be rejected before queued
+func packMessage(session *session.Session, m *message.Message) ([]byte, error) {
+	p := protocolOf(session)
+	c := p.codec
+	r := m.Route
+	if m.Type == message.Response {
+		r = session.LastRoute
+	}
+	if enable, ok := env.routeCompression[r]; p.compress && (!ok || enable) {
+		m.Deflate = true
 	}
-	ep, err := p.Pack()
+
+	em, err := c.Encode(m)
 	if err != nil {
 		log.Errorf(err.Error())
-		return err
+		return nil, err
 	}
 
-	t.send(session, ep)
-	return nil
+	// sealed data is longer than message by the GCM tag
+	size := len(em)
+	if p.encrypt {
+		size += sealOverhead
+	}
+	if size > maxMessageSize() {
+		log.Errorf("Message too large, Route=%s, Length=%d", m.Route, size)
+		return nil, ErrMessageTooLarge
+	}
+
+	ep, err := c.Pack(&packet.Packet{Type: packet.Data, Data: em})
+	if err != nil {
+		log.Errorf(err.Error())
+		return nil, err
+	}
+	return ep, nil
+}
+
+// maxMessageSize returns the max outbound message length
+func maxMessageSize() int {
+	if app.config == nil || app.config.MaxMessageSize <= 0 {
+		return packet.DefaultMaxPacketSize
+	}
+	return app.config.MaxMessageSize
 }
 
 // TODO: implement backend server broadcast
@@ -195,8 +308,11 @@ func (t *transportService) broadcast(route string, data []byte) {
 		return
 	}
 
-	for _, s := range t.agents {
-		t.push(s.session, route, data)
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, agent := range t.agents {
+		t.push(agent.Session(), route, data)
 	}
 }
 
@@ -207,7 +323,7 @@ func (t *transportService) multicast(aids []int64, route string, data []byte) {
 
 	for _, aid := range aids {
 		if agent, ok := t.agents[aid]; ok && agent != nil {
-			t.push(agent.session, route, data)
+			t.push(agent.Session(), route, data)
 		}
 	}
 }
@@ -217,10 +333,17 @@ func (t *transportService) Session(sid int64) (*session.Session, error) {
 	defer t.RUnlock()
 
 	a, ok := t.agents[sid]
-	if !ok {
-		return nil, ErrSessionNotFound
+	if ok {
+		return a.Session(), nil
+	}
+
+	// suspended session could receive reliable pushes
+	for _, ss := range t.suspended {
+		if ss.session.ID == sid {
+			return ss.session, nil
+		}
 	}
-	return a.session, nil
+	return nil, ErrSessionNotFound
 }
 
 // Close session
@@ -233,22 +356,22 @@ func (t *transportService) closeSession(session *session.Session) {
 	}
 	t.sessionCloseCbLock.RUnlock()
 
+	if app.config.IsFrontend {
+		t.closeOutbox(session)
+	}
+
 	t.Lock()
 	defer t.Unlock()
 
 	if app.config.IsFrontend {
-		if agent, ok := t.agents[session.Entity.ID()]; ok && (agent != nil) {
-			delete(t.agents, session.Entity.ID())
+		if agent, ok := t.agents[session.NetworkEntity().ID()]; ok && (agent != nil) {
+			delete(t.agents, session.NetworkEntity().ID())
 		}
 		// notify all backend server, current session has been closed.
 		cluster.SessionClosed(session)
 	} else {
-		if acceptor, ok := t.acceptors[session.Entity.ID()]; ok && (acceptor != nil) {
-			delete(acceptor.sessionMap, session.ID)
-			if fid, ok := acceptor.b2fMap[session.ID]; ok {
-				delete(acceptor.b2fMap, session.ID)
-				delete(acceptor.f2bMap, fid)
-			}
+		if acceptor, ok := t.acceptors[session.NetworkEntity().ID()]; ok && (acceptor != nil) {
+			acceptor.removeSession(session.ID)
 		}
 	}
 }
@@ -260,31 +383,47 @@ func (t *transportService) removeAcceptor(a *acceptor) {
 	delete(t.acceptors, a.id)
 }
 
-// Send heartbeat packet
-func (t *transportService) heartbeat() {
-	if !app.config.IsFrontend || t.agents == nil {
+// Heartbeat ticks per heartbeat interval and slots of the timing wheel
+const (
+	heartbeatTicks = 10
+	heartbeatSlots = 64
+)
+
+// startHeartbeat starts the heartbeat timing wheel, agents are hashed into
+// the wheel by the time of heartbeat check, so only a small part of agents
+// are checked on every tick
+func (t *transportService) startHeartbeat(interval time.Duration) {
+	if interval <= 0 {
 		return
 	}
-	dt := time.Now().Add(-2 * env.heartbeatInternal)
-	dtu := dt.Unix()
 
-	for _, agent := range t.agents {
-		if agent.status != statusWorking {
-			continue
-		}
+	t.wheel = timer.NewWheel(interval/heartbeatTicks, heartbeatSlots)
+	t.wheel.Start()
+}
 
-		if agent.lastTime < dtu {
-			log.Debugf("Session heartbeat timeout, LastTime=%d, Deadline=%d", agent.lastTime, dtu)
-			agent.Close()
-			continue
-		}
+// heartbeat checks agent in the wheel goroutine, heartbeat packet is only
+// sent to the agent which has received nothing for a heartbeat interval, and
+// idle agent will be closed by the read deadline
+func (t *transportService) heartbeat(a *agent) {
+	if a.loadStatus() == statusClosed {
+		return
+	}
 
-		if err := agent.Send(heartbeatPacket); err != nil {
-			log.Error(err)
-			agent.Close()
-			continue
+	interval := env.heartbeatInternal
+	quiet := a.quiet()
+	if quiet >= interval {
+		if a.loadStatus() == statusWorking {
+			p, err := a.codec().Pack(&packet.Packet{Type: packet.Heartbeat})
+			if err != nil {
+				log.Error(err)
+			} else if !a.trySend(p) {
+				log.Debugf("Send queue full, heartbeat skipped, %s", a.String())
+			}
 		}
+		quiet = 0
 	}
+
+	a.heartbeatTimer.Reset(interval - quiet)
 }
 
 // Dump all agents
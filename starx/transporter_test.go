@@ -1,14 +1,222 @@
 package starx
 
 import (
+	"net"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/lonnng/starx/packet"
+	"github.com/chrislonng/starx/codec"
+	"github.com/chrislonng/starx/message"
+	"github.com/chrislonng/starx/packet"
 )
 
 func Test1(t *testing.T) {
-	if !reflect.DeepEqual(heartbeatPacket, append([]byte{packet.Heartbeat, 0x00, 0x00, 0x00})) {
+	heartbeatPacket, err := codec.Default.Pack(&packet.Packet{Type: packet.Heartbeat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(heartbeatPacket, []byte{packet.Heartbeat, 0x00, 0x00, 0x00}) {
 		t.Error("wrong heartbeat packet")
 	}
 }
+
+func TestPackMessageCompression(t *testing.T) {
+	defer delete(env.routeCompression, "test.raw")
+	SetRouteCompression("test.raw", false)
+
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	a.setProtocol(wireProtocol{codec: codec.Default, compress: true})
+
+	deflated := func(m *message.Message) bool {
+		data, err := packMessage(a.session, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _, err := codec.Default.Unpack(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm, err := codec.Default.Decode(p.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dm.Deflate
+	}
+
+	data := []byte(strings.Repeat("hello world", 200))
+	if !deflated(&message.Message{Type: message.Push, Route: "test.push", Data: data}) {
+		t.Error("push should be deflated")
+	}
+	if deflated(&message.Message{Type: message.Push, Route: "test.raw", Data: data}) {
+		t.Error("compression disabled route should not be deflated")
+	}
+
+	// response follows the setting of request route
+	a.session.LastID, a.session.LastRoute = 1, "test.raw"
+	if deflated(&message.Message{Type: message.Response, ID: 1, Data: data}) {
+		t.Error("response of compression disabled route should not be deflated")
+	}
+	a.session.LastRoute = "test.push"
+	if !deflated(&message.Message{Type: message.Response, ID: 1, Data: data}) {
+		t.Error("response should be deflated")
+	}
+}
+
+func TestPackMessageTooLarge(t *testing.T) {
+	defer func(n int) { app.config.MaxMessageSize = n }(app.config.MaxMessageSize)
+	app.config.MaxMessageSize = 64
+
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+
+	m := &message.Message{Type: message.Push, Route: "test.push", Data: []byte("hello")}
+	if _, err := packMessage(a.session, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// oversized message is rejected before queued
+	data := []byte(strings.Repeat("hello world", 10))
+	m = &message.Message{Type: message.Push, Route: "test.push", Data: data}
+	if _, err := packMessage(a.session, m); err != ErrMessageTooLarge {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+	if err := a.session.Push("test.push", data); err != ErrMessageTooLarge {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+	if a.queueDepth() != 0 {
+		t.Error("oversized message should not be queued")
+	}
+
+	// sealed data is longer than message by the GCM tag
+	em, err := a.codec().Encode(&message.Message{Type: message.Push, Route: "test.push", Data: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config.MaxMessageSize = len(em)
+	m = &message.Message{Type: message.Push, Route: "test.push", Data: []byte("hello")}
+	if _, err := packMessage(a.session, m); err != nil {
+		t.Fatal(err)
+	}
+	a.setProtocol(wireProtocol{codec: codec.Default, encrypt: true})
+	m = &message.Message{Type: message.Push, Route: "test.push", Data: []byte("hello")}
+	if _, err := packMessage(a.session, m); err != ErrMessageTooLarge {
+		t.Fatalf("expect ErrMessageTooLarge when sealed, got %v", err)
+	}
+}
+
+func TestHeartbeatQuiet(t *testing.T) {
+	defer func(d time.Duration) { env.heartbeatInternal = d }(env.heartbeatInternal)
+	env.heartbeatInternal = time.Second
+
+	ts := newTransporter()
+	ts.startHeartbeat(env.heartbeatInternal)
+	defer ts.wheel.Stop()
+
+	conn, _ := net.Pipe()
+	a := newAgent(conn)
+	a.status = statusWorking
+	a.heartbeatTimer = ts.wheel.NewTimer(func() {})
+	defer a.heartbeatTimer.Stop()
+
+	// client receiving pushes but sending nothing still needs heartbeat
+	atomic.StoreInt64(&a.lastTime, time.Now().Add(-env.heartbeatInternal).UnixNano())
+	ts.heartbeat(a)
+	if a.queueDepth() != 1 {
+		t.Fatal("heartbeat should be sent to quiet client")
+	}
+	a.queue.pop()
+
+	a.heartbeat()
+	ts.heartbeat(a)
+	if a.queueDepth() != 0 {
+		t.Error("heartbeat should not be sent to active client")
+	}
+}
+
+// BenchmarkHeartbeat_100kSessions drives the timing wheel with 100k agents,
+// every operation is a heartbeat interval in which all agents are checked,
+// half of agents are quiet and need heartbeat packet
+func BenchmarkHeartbeat_100kSessions(b *testing.B) {
+	const sessions = 100000
+
+	defer func(d time.Duration) { env.heartbeatInternal = d }(env.heartbeatInternal)
+	env.heartbeatInternal = 100 * time.Millisecond
+
+	t := newTransporter()
+	t.startHeartbeat(env.heartbeatInternal)
+	defer t.wheel.Stop()
+
+	var checked int64
+	agents := make([]*agent, 0, sessions)
+	for i := 0; i < sessions; i++ {
+		conn, _ := net.Pipe()
+		a := newAgent(conn)
+		a.status = statusWorking
+		a.heartbeatTimer = t.wheel.NewTimer(func() {
+			t.heartbeat(a)
+			atomic.AddInt64(&checked, 1)
+		})
+		agents = append(agents, a)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		atomic.StoreInt64(&checked, 0)
+		for j, a := range agents {
+			if j%2 == 0 {
+				atomic.StoreInt64(&a.lastTime, 0)
+			} else {
+				atomic.StoreInt64(&a.lastTime, time.Now().UnixNano())
+			}
+			a.heartbeatTimer.Reset(time.Duration(j%heartbeatTicks) * env.heartbeatInternal / heartbeatTicks)
+		}
+		for atomic.LoadInt64(&checked) < sessions {
+			time.Sleep(env.heartbeatInternal / heartbeatTicks)
+		}
+	}
+	b.StopTimer()
+
+	for _, a := range agents {
+		a.heartbeatTimer.Stop()
+	}
+}
+
+func TestTransporterResume(t *testing.T) {
+	defer func(d time.Duration) { env.resumeGrace = d }(env.resumeGrace)
+	env.resumeGrace = time.Minute
+
+	ts := newTransporter()
+
+	conn1, _ := net.Pipe()
+	old := newAgent(conn1)
+	old.resumeToken = "token"
+	ts.agents[old.id] = old
+	ts.suspend(old)
+	if !ts.isSuspended(old) {
+		t.Fatal("session should be suspended")
+	}
+
+	conn2, _ := net.Pipe()
+	a := newAgent(conn2)
+	ts.agents[a.id] = a
+	if ts.resume(a, "invalid") {
+		t.Fatal("resume with invalid token")
+	}
+	if !ts.resume(a, "token") {
+		t.Fatal("resume failed")
+	}
+	if a.session != old.session || a.id != old.session.ID || a.session.Entity != a {
+		t.Error("agent should reattach to the previous session")
+	}
+	if len(ts.agents) != 1 || ts.agents[a.id] != a {
+		t.Error("wrong agents after resume")
+	}
+	if ts.isSuspended(old) || ts.resume(a, "token") {
+		t.Error("resume token should be used only once")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) starx Author. All Rights Reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package starx
+
+import (
+	"net"
+	"time"
+
+	"github.com/chrislonng/starx/transport"
+)
+
+// Max packets coalesced in a single vectored write by default
+const defaultMaxCoalescePackets = 64
+
+// packetWriter coalesces outbound packets of an agent, all ready packets in
+// send queue will be written with a single vectored write, and packets could
+// be delayed up to the flush interval to batch more packets.
+// It is only used in the agent logic goroutine.
+type packetWriter struct {
+	agent   *agent
+	pending net.Buffers      // sealed packets waiting for flush
+	kick    bool             // kick packet pending, stop coalescing
+	timer   *time.Timer      // flush interval timer
+	C       <-chan time.Time // fired when flush interval expired, nil when timer not armed
+}
+
+func newPacketWriter(a *agent) *packetWriter {
+	return &packetWriter{agent: a}
+}
+
+// maxCoalescePackets returns the configured coalescing limit, packets are
+// written one by one when it is not positive
+func maxCoalescePackets() int {
+	if env.maxCoalescePackets < 1 {
+		return 1
+	}
+	return env.maxCoalescePackets
+}
+
+// drain appends all ready packets in send queue in order, it stops after a
+// kick packet or the coalescing limit reached
+func (w *packetWriter) drain() error {
+	for !w.kick && len(w.pending) < maxCoalescePackets() {
+		m, ok := w.agent.queue.pop()
+		if !ok {
+			return nil
+		}
+		if m == nil {
+			continue
+		}
+		if err := w.append(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *packetWriter) append(data []byte) error {
+	m, err := w.agent.seal(data)
+	if err != nil {
+		return err
+	}
+
+	w.pending = append(w.pending, m)
+	if isKickPacket(m) {
+		w.kick = true
+	}
+	return nil
+}
+
+// ready reports whether pending packets should be flushed now, the flush
+// timer will be armed when packets could be delayed
+func (w *packetWriter) ready(interval time.Duration) bool {
+	if w.kick || interval <= 0 || len(w.pending) >= maxCoalescePackets() {
+		return true
+	}
+
+	if w.C == nil {
+		if w.timer == nil {
+			w.timer = time.NewTimer(interval)
+		} else {
+			w.timer.Reset(interval)
+		}
+		w.C = w.timer.C
+	}
+	return false
+}
+
+// flush writes all pending packets, vectored write will be used when the
+// socket or the connection wrapped by it supports
+func (w *packetWriter) flush() error {
+	if w.C != nil {
+		w.timer.Stop()
+		w.C = nil
+	}
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	var err error
+	bufs := w.pending
+	if bw, ok := w.agent.socket.(transport.BuffersWriter); ok {
+		_, err = bw.WriteBuffers(&bufs)
+	} else {
+		_, err = bufs.WriteTo(w.agent.socket)
+	}
+
+	// release references of written packets
+	for i := range w.pending {
+		w.pending[i] = nil
+	}
+	w.pending = w.pending[:0]
+	return err
+}
@@ -0,0 +1,52 @@
+package starx
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chrislonng/starx/packet"
+)
+
+func TestPacketWriterCoalesceLimit(t *testing.T) {
+	defer func(n int) { env.maxCoalescePackets = n }(env.maxCoalescePackets)
+
+	data, err := packet.Pack(&packet.Packet{Type: packet.Data, Data: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		limit   int
+		pending int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+		{defaultMaxCoalescePackets, 8},
+	}
+
+	for _, c := range cases {
+		SetMaxCoalescePackets(c.limit)
+
+		conn, _ := net.Pipe()
+		a := newAgent(conn)
+		for i := 0; i < 8; i++ {
+			a.queue.tryPush(data, false)
+		}
+
+		w := newPacketWriter(a)
+		if err := w.drain(); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.pending) != c.pending {
+			t.Errorf("expect %d packets coalesced with limit %d, got %d", c.pending, c.limit, len(w.pending))
+		}
+		if ready := w.ready(time.Hour); ready != (c.pending < 8) {
+			t.Errorf("expect ready %v with limit %d", c.pending < 8, c.limit)
+		}
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+	}
+}